@@ -0,0 +1,136 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// 审计日志文件,每行一条json记录,只追加不修改
+const auditFile = "audit.jsonl"
+
+// Entry 一条审计记录
+type Entry struct {
+	Time   time.Time              `json:"time"`
+	Action string                 `json:"action"`
+	User   string                 `json:"user,omitempty"`
+	IP     string                 `json:"ip,omitempty"`
+	Detail map[string]interface{} `json:"detail,omitempty"`
+}
+
+var mu sync.Mutex
+
+// Record 追加一条审计记录,写入失败只记录日志,不影响业务
+func Record(entry Entry) {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("Failed to marshal audit entry: %v", err)
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	file, err := os.OpenFile(auditFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Printf("Failed to open audit log: %v", err)
+		return
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		log.Printf("Failed to write audit log: %v", err)
+	}
+}
+
+// Filter 查询条件,为空的条件不限制
+type Filter struct {
+	Action string    // 操作,如 login、job_start
+	User   string    // 用户名
+	IP     string    // 来源IP
+	Since  time.Time // 不早于该时间
+	Until  time.Time // 早于该时间
+}
+
+// Match 判断记录是否满足查询条件
+func (f Filter) Match(entry Entry) bool {
+	switch {
+	case f.Action != "" && entry.Action != f.Action:
+		return false
+	case f.User != "" && entry.User != f.User:
+		return false
+	case f.IP != "" && entry.IP != f.IP:
+		return false
+	case !f.Since.IsZero() && entry.Time.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && !entry.Time.Before(f.Until):
+		return false
+	}
+	return true
+}
+
+// scan 按写入顺序读取审计日志,对满足条件的记录调用fn,无法解析的行跳过
+func scan(f Filter, fn func(entry Entry, line []byte) error) error {
+	file, err := os.Open(auditFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	// 任务参数中的消息可能很长
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		if !f.Match(entry) {
+			continue
+		}
+		if err := fn(entry, scanner.Bytes()); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// Query 返回满足条件的记录,最新的在前,limit不大于0时不限制数量
+func Query(f Filter, limit int) ([]Entry, error) {
+	var entries []Entry
+	err := scan(f, func(entry Entry, _ []byte) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
+
+// Export 将满足条件的记录按写入顺序以原始的jsonl格式写入w
+func Export(w io.Writer, f Filter) error {
+	return scan(f, func(_ Entry, line []byte) error {
+		if _, err := w.Write(line); err != nil {
+			return err
+		}
+		_, err := w.Write([]byte{'\n'})
+		return err
+	})
+}
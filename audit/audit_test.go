@@ -0,0 +1,98 @@
+package audit
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+var base = time.Date(2026, 10, 16, 10, 0, 0, 0, time.UTC)
+
+func TestFilterMatch(t *testing.T) {
+	entry := Entry{Time: base, Action: "login", User: "admin", IP: "10.0.0.1"}
+	tests := []struct {
+		filter Filter
+		want   bool
+	}{
+		{Filter{}, true},
+		{Filter{Action: "login"}, true},
+		{Filter{Action: "job_start"}, false},
+		{Filter{User: "admin", IP: "10.0.0.1"}, true},
+		{Filter{User: "op"}, false},
+		{Filter{IP: "10.0.0.2"}, false},
+		// Since包含该时刻,Until不包含
+		{Filter{Since: base}, true},
+		{Filter{Since: base.Add(time.Second)}, false},
+		{Filter{Until: base.Add(time.Second)}, true},
+		{Filter{Until: base}, false},
+		{Filter{Since: base.Add(-time.Hour), Until: base.Add(time.Hour), Action: "login"}, true},
+		{Filter{Since: base.Add(-time.Hour), Until: base.Add(time.Hour), Action: "logout"}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.filter.Match(entry); got != tt.want {
+			t.Errorf("%+v Match = %v, want %v", tt.filter, got, tt.want)
+		}
+	}
+}
+
+func TestQuery(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	if entries, err := Query(Filter{}, 0); err != nil || len(entries) != 0 {
+		t.Fatalf("Query without log = %v, %v, want none", entries, err)
+	}
+
+	for i, action := range []string{"login", "job_start", "login", "job_end"} {
+		Record(Entry{Time: base.Add(time.Duration(i) * time.Minute), Action: action, User: "admin"})
+	}
+	// 无法解析的行跳过
+	file, err := os.OpenFile(auditFile, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString("not json\n")
+	file.Close()
+	Record(Entry{Action: "login", User: "op"})
+
+	tests := []struct {
+		filter Filter
+		limit  int
+		want   []string
+	}{
+		{Filter{}, 0, []string{"login", "job_end", "login", "job_start", "login"}},
+		{Filter{}, 2, []string{"login", "job_end"}},
+		{Filter{Action: "login", User: "admin"}, 0, []string{"login", "login"}},
+		{Filter{Since: base.Add(time.Minute), Until: base.Add(3 * time.Minute)}, 0, []string{"login", "job_start"}},
+	}
+	for _, tt := range tests {
+		entries, err := Query(tt.filter, tt.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var actions []string
+		for _, e := range entries {
+			actions = append(actions, e.Action)
+		}
+		if got, want := strings.Join(actions, " "), strings.Join(tt.want, " "); got != want {
+			t.Errorf("Query(%+v, %d) = %s, want %s", tt.filter, tt.limit, got, want)
+		}
+	}
+
+	// 导出按写入顺序,保留原始内容
+	var buf bytes.Buffer
+	if err := Export(&buf, Filter{Action: "job_start"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); !strings.HasPrefix(got, `{"time":"2026-10-16T10:01:00Z","action":"job_start"`) || strings.Count(got, "\n") != 1 {
+		t.Errorf("Export = %q", got)
+	}
+}
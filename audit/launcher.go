@@ -0,0 +1,41 @@
+package audit
+
+import (
+	"os"
+
+	"github.com/google/uuid"
+)
+
+// WebUI启动发送任务时设置的环境变量。启动者已经记录了job_start,发送任务只记录job_end
+const (
+	LauncherEnv   = "GSK_BROADCAST_LAUNCHER"    // 启动的用户,定时任务为scheduler
+	LauncherIPEnv = "GSK_BROADCAST_LAUNCHER_IP" // 启动者的来源IP,定时任务为空
+	JobIDEnv      = "GSK_BROADCAST_JOB"         // 任务ID,job_start和job_end记录相同的ID
+)
+
+// Launcher 发送任务的启动者
+type Launcher struct {
+	User  string
+	IP    string
+	JobID string
+}
+
+// NewJobID 生成关联job_start和job_end的任务ID
+func NewJobID() string {
+	return uuid.New().String()[:8]
+}
+
+// Env 返回启动发送任务时需要添加的环境变量
+func (l Launcher) Env() []string {
+	return []string{LauncherEnv + "=" + l.User, LauncherIPEnv + "=" + l.IP, JobIDEnv + "=" + l.JobID}
+}
+
+// LauncherFromEnv 读取启动者,命令行直接运行时返回false
+func LauncherFromEnv() (Launcher, bool) {
+	l := Launcher{
+		User:  os.Getenv(LauncherEnv),
+		IP:    os.Getenv(LauncherIPEnv),
+		JobID: os.Getenv(JobIDEnv),
+	}
+	return l, l.User != ""
+}
@@ -0,0 +1,23 @@
+package audit
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLauncherFromEnv(t *testing.T) {
+	t.Setenv(LauncherEnv, "")
+	if _, ok := LauncherFromEnv(); ok {
+		t.Fatal("launched without a launcher")
+	}
+
+	want := Launcher{User: "admin", IP: "10.0.0.1", JobID: NewJobID()}
+	for _, env := range want.Env() {
+		key, value, _ := strings.Cut(env, "=")
+		t.Setenv(key, value)
+	}
+	got, ok := LauncherFromEnv()
+	if !ok || got != want {
+		t.Errorf("LauncherFromEnv = %+v, %v, want %+v", got, ok, want)
+	}
+}
@@ -0,0 +1,500 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hoshinonyaruko/gensokyo-broadcast/audit"
+	"github.com/hoshinonyaruko/gensokyo-broadcast/config"
+	"github.com/hoshinonyaruko/gensokyo-broadcast/exclude"
+	"github.com/hoshinonyaruko/gensokyo-broadcast/listfile"
+	"github.com/hoshinonyaruko/gensokyo-broadcast/lockout"
+	"github.com/hoshinonyaruko/gensokyo-broadcast/snapshot"
+	"github.com/hoshinonyaruko/gensokyo-broadcast/txt"
+)
+
+// runSubcommand 处理子命令,如 exclude,不是子命令时返回false
+func runSubcommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	switch args[0] {
+	case "exclude":
+		runExcludeCommand(args[1:])
+	case "list":
+		runListCommand(args[1:])
+	case "snapshot":
+		runSnapshotCommand(args[1:])
+	case "passwd":
+		runPasswdCommand(args[1:])
+	case "unlock":
+		runUnlockCommand(args[1:])
+	default:
+		return false
+	}
+	return true
+}
+
+// runExcludeCommand 管理排除列表
+//
+//	exclude list
+//	exclude add -type group -value 123456 -reason 合作群 -expire 2025-12-31
+//	exclude rm 3
+func runExcludeCommand(args []string) {
+	if len(args) == 0 {
+		showExcludeHelp()
+		return
+	}
+
+	switch args[0] {
+	case "list":
+		entries, err := exclude.Load()
+		if err != nil {
+			log.Fatalf("Failed to load exclusions: %v", err)
+		}
+		if len(entries) == 0 {
+			fmt.Println("排除列表为空")
+			return
+		}
+		now := time.Now()
+		for _, e := range entries {
+			expire := "永久"
+			if !e.Expire.IsZero() {
+				expire = e.Expire.Format(timeLayout)
+				if e.Expired(now) {
+					expire += "(已过期)"
+				}
+			}
+			fmt.Printf("[%s] %s %s 原因:%s 有效期至:%s\n", e.ID, e.Type, e.Value, e.Reason, expire)
+		}
+	case "add":
+		fs := flag.NewFlagSet("exclude add", flag.ExitOnError)
+		entryType := fs.String("type", exclude.TypeGroup, "排除类型 group/user/name")
+		value := fs.String("value", "", "群号、QQ号或名称正则")
+		reason := fs.String("reason", "", "排除原因")
+		expire := fs.String("expire", "", "有效期至,格式2006-01-02,不填为永久")
+		fs.Parse(args[1:])
+
+		entry := exclude.Entry{
+			Type:   *entryType,
+			Value:  strings.TrimSpace(*value),
+			Reason: *reason,
+			Source: "cli",
+		}
+		if *expire != "" {
+			t, err := time.ParseInLocation("2006-01-02", *expire, time.Local)
+			if err != nil {
+				log.Fatalf("Invalid expire date: %v", err)
+			}
+			// 有效期包含当天
+			entry.Expire = t.Add(24*time.Hour - time.Second)
+		}
+		entry, err := exclude.Add(entry)
+		if err != nil {
+			log.Fatalf("Failed to add exclusion: %v", err)
+		}
+		audit.Record(audit.Entry{
+			Action: "exclusion_add",
+			User:   "cli",
+			Detail: map[string]interface{}{"id": entry.ID, "type": entry.Type, "value": entry.Value, "reason": entry.Reason},
+		})
+		fmt.Printf("已添加排除记录[%s] %s %s\n", entry.ID, entry.Type, entry.Value)
+	case "rm":
+		if len(args) < 2 {
+			log.Fatalf("Usage: exclude rm <id>")
+		}
+		if err := exclude.Remove(args[1]); err != nil {
+			log.Fatalf("Failed to remove exclusion: %v", err)
+		}
+		audit.Record(audit.Entry{
+			Action: "exclusion_remove",
+			User:   "cli",
+			Detail: map[string]interface{}{"id": args[1]},
+		})
+		fmt.Printf("已删除排除记录[%s]\n", args[1])
+	default:
+		showExcludeHelp()
+		os.Exit(1)
+	}
+}
+
+func showExcludeHelp() {
+	fmt.Println("排除列表(免打扰列表)管理,所有任务发送前都会检查:")
+	fmt.Println("exclude list  列出全部排除记录")
+	fmt.Println("exclude add -type group -value 123456 -reason 合作群 -expire 2025-12-31  添加记录,type为group/user/name,name为群名、昵称或备注的正则")
+	fmt.Println("exclude rm 3  按编号删除记录")
+}
+
+// runListCommand 计算列表表达式,预览结果或保存为新的列表文件
+//
+//	list eval -a http://127.0.0.1:8080 -o 新列表 "api:groups - save:上周活动 & vip群"
+func runListCommand(args []string) {
+	if len(args) == 0 || args[0] != "eval" {
+		showListHelp()
+		return
+	}
+
+	fs := flag.NewFlagSet("list eval", flag.ExitOnError)
+	apiAddress := fs.String("a", "", "HTTP API 的地址,使用api:groups或api:friends时需要")
+	token := fs.String("t", "", "access_token")
+	output := fs.String("o", "", "保存结果的列表文件名(不包括.txt后缀)")
+	overwrite := fs.Bool("force", false, "覆盖已存在的列表文件")
+	asJSON := fs.Bool("json", false, "以json格式输出结果")
+	tags := fs.String("tags", "", "只保留带有这些标签的目标,逗号分隔")
+	fs.Parse(args[1:])
+
+	ts := txt.GetInstance()
+	loadTagFile(ts)
+	expr := strings.Join(fs.Args(), " ")
+	ids, err := evalListExpr(ts, *apiAddress, *token, expr)
+	if err != nil {
+		log.Fatalf("Failed to evaluate list expression: %v", err)
+	}
+	if *tags != "" {
+		ids = filterByTags(ids, listfile.ParseTags(*tags))
+	}
+
+	if *output != "" {
+		if listFileExists(*output) && !*overwrite {
+			log.Fatalf("List file %s.txt already exists, use -force to overwrite", *output)
+		}
+		if err := saveIDList(ids, *output+".txt"); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+
+	if *asJSON {
+		if ids == nil {
+			ids = []int64{}
+		}
+		data, _ := json.Marshal(map[string]interface{}{
+			"expr":  expr,
+			"count": len(ids),
+			"ids":   ids,
+			"saved": *output,
+		})
+		fmt.Println(string(data))
+		return
+	}
+
+	for _, id := range ids {
+		fmt.Println(id)
+	}
+	fmt.Printf("列表表达式'%s'的结果为%d个群或好友\n", expr, len(ids))
+	if *output != "" {
+		fmt.Printf("已保存到'%s.txt'\n", *output)
+	}
+}
+
+func showListHelp() {
+	fmt.Println("列表表达式,用 + 并集、& 交集、- 差集组合多个列表,从左到右计算,可用括号:")
+	fmt.Println("list eval [-a API地址] [-t access_token] [-tags 标签] [-o 新列表名] [-force] [-json] 表达式")
+	fmt.Println("运算符两侧必须有空格,因为列表名中可以有-,如 a-b 是名为a-b的列表, a - b 才是差集")
+	fmt.Println("操作数: 列表文件名(或txt:列表名)、api:groups、api:friends、save:存档名(该任务发送成功的目标)")
+	fmt.Println("        joined:快照引用、left:快照引用(自该快照以来新加入、已退出的群),好友为joined-friends:、left-friends:")
+	fmt.Println("示例: list eval -a http://127.0.0.1:8080 -o 本周目标 \"api:groups - save:上周活动 & vip群\"")
+}
+
+// runSnapshotCommand 管理群和好友列表快照
+//
+//	snapshot take -a http://127.0.0.1:8080
+//	snapshot list -a http://127.0.0.1:8080
+//	snapshot diff -a http://127.0.0.1:8080 -from 2024-06-01
+func runSnapshotCommand(args []string) {
+	if len(args) == 0 {
+		showSnapshotHelp()
+		return
+	}
+
+	fs := flag.NewFlagSet("snapshot "+args[0], flag.ExitOnError)
+	apiAddress := fs.String("a", "", "HTTP API 的地址")
+	token := fs.String("t", "", "access_token")
+	bot := fs.String("bot", "", "机器人QQ号,不填则通过-a获取")
+	friends := fs.Bool("friends", false, "好友列表快照")
+	from := fs.String("from", snapshot.RefPrev, "diff的起点快照")
+	to := fs.String("to", snapshot.RefLatest, "diff的终点快照")
+	output := fs.String("o", "", "将diff中新加入的目标保存为列表文件(不包括.txt后缀)")
+	asJSON := fs.Bool("json", false, "以json格式输出diff结果")
+	fs.Parse(args[1:])
+
+	kind := snapshot.KindGroups
+	if *friends {
+		kind = snapshot.KindFriends
+	}
+
+	switch args[0] {
+	case "take":
+		if *apiAddress == "" {
+			log.Fatalf("snapshot take requires -a")
+		}
+		if *friends {
+			_, err := fetchFriendList(*apiAddress, *token, false)
+			if err != nil {
+				log.Fatalf("Failed to fetch friend list: %v", err)
+			}
+		} else {
+			_, err := fetchGroupList(*apiAddress, *token, false)
+			if err != nil {
+				log.Fatalf("Failed to fetch group list: %v", err)
+			}
+		}
+		botID := snapshotBot(*apiAddress, *token, *bot)
+		latest, err := snapshot.Load(botID, kind, snapshot.RefLatest)
+		if err != nil {
+			log.Fatalf("Failed to save snapshot: %v", err)
+		}
+		fmt.Printf("机器人%s的%s快照: %s 共%d个\n", botID, kind, time.Unix(latest.Time, 0).Format(timeLayout), latest.Count)
+	case "list":
+		botID := snapshotBot(*apiAddress, *token, *bot)
+		infos, err := snapshot.List(botID, kind)
+		if err != nil {
+			log.Fatalf("Failed to list snapshots: %v", err)
+		}
+		if len(infos) == 0 {
+			fmt.Printf("机器人%s没有%s快照\n", botID, kind)
+			return
+		}
+		for _, info := range infos {
+			s, err := snapshot.Read(info.Path)
+			if err != nil {
+				log.Printf("%v", err)
+				continue
+			}
+			fmt.Printf("%d  %s  共%d个  %s\n", info.Time, time.Unix(info.Time, 0).Format(timeLayout), s.Count, info.Path)
+		}
+	case "diff":
+		botID := snapshotBot(*apiAddress, *token, *bot)
+		ts := txt.GetInstance()
+		fromSnapshot, err := resolveSnapshotRef(ts, botID, kind, *from)
+		if err != nil {
+			log.Fatalf("Failed to load snapshot %s: %v", *from, err)
+		}
+		toSnapshot, err := resolveSnapshotRef(ts, botID, kind, *to)
+		if err != nil {
+			log.Fatalf("Failed to load snapshot %s: %v", *to, err)
+		}
+		joined, left := snapshot.Diff(fromSnapshot, toSnapshot)
+
+		if *output != "" {
+			if err := saveIDList(snapshot.IDs(joined), *output+".txt"); err != nil {
+				log.Fatalf("%v", err)
+			}
+		}
+
+		if *asJSON {
+			data, _ := json.Marshal(map[string]interface{}{
+				"bot":    botID,
+				"kind":   kind,
+				"from":   fromSnapshot.Time,
+				"to":     toSnapshot.Time,
+				"joined": nonNilTargets(joined),
+				"left":   nonNilTargets(left),
+			})
+			fmt.Println(string(data))
+			return
+		}
+
+		fmt.Printf("机器人%s的%s快照 %s(%d个) -> %s(%d个)\n", botID, kind,
+			time.Unix(fromSnapshot.Time, 0).Format(timeLayout), fromSnapshot.Count,
+			time.Unix(toSnapshot.Time, 0).Format(timeLayout), toSnapshot.Count)
+		fmt.Printf("新加入%d个:\n", len(joined))
+		for _, target := range joined {
+			fmt.Printf("  + %d %s\n", target.ID, target.Name)
+		}
+		fmt.Printf("已退出%d个:\n", len(left))
+		for _, target := range left {
+			fmt.Printf("  - %d %s\n", target.ID, target.Name)
+		}
+		if *output != "" {
+			fmt.Printf("新加入的目标已保存到'%s.txt'\n", *output)
+		}
+	default:
+		showSnapshotHelp()
+		os.Exit(1)
+	}
+}
+
+// snapshotBot 确定快照所属的机器人,依次使用-bot、-a获取的登录信息、唯一已有快照的机器人
+func snapshotBot(apiAddress string, token string, bot string) string {
+	if bot != "" {
+		return bot
+	}
+	if apiAddress != "" {
+		info, err := fetchBotInfo(apiAddress, token)
+		if err != nil {
+			log.Fatalf("Failed to get login info: %v", err)
+		}
+		return info.ID
+	}
+	bots, err := snapshot.Bots()
+	if err != nil {
+		log.Fatalf("Failed to list snapshots: %v", err)
+	}
+	if len(bots) != 1 {
+		log.Fatalf("Please specify the bot with -a or -bot, snapshots found for: %v", bots)
+	}
+	return bots[0]
+}
+
+func nonNilTargets(targets []snapshot.Target) []snapshot.Target {
+	if targets == nil {
+		return []snapshot.Target{}
+	}
+	return targets
+}
+
+func showSnapshotHelp() {
+	fmt.Println("群和好友列表快照,每次从API获取列表时自动保存到snapshots/机器人QQ/目录,列表没有变化时不重复保存:")
+	fmt.Println("snapshot take -a API地址 [-t access_token] [-friends]  立即获取并保存快照")
+	fmt.Println("snapshot list [-a API地址 | -bot 机器人QQ] [-friends]  列出快照")
+	fmt.Println("snapshot diff [-a API地址 | -bot 机器人QQ] [-friends] [-from prev] [-to latest] [-o 新列表名] [-json]  比较两个快照")
+	fmt.Println("快照引用: latest、prev、unix时间戳、日期时间(2024-06-01、2024-06-01T22:00)、save:存档名(该任务第一次发送时的快照)")
+}
+
+// runPasswdCommand 重置WebUI的登入密码,忘记密码时使用
+//
+//	passwd
+//	passwd -account admin -password 新密码
+//	passwd -reset
+func runPasswdCommand(args []string) {
+	fs := flag.NewFlagSet("passwd", flag.ExitOnError)
+	account := fs.String("account", "", "同时修改登入用户名,不填则不修改")
+	password := fs.String("password", "", "新密码,不填则从输入读取")
+	temporary := fs.Bool("temp", false, "作为临时密码,下次登入后必须修改")
+	reset := fs.Bool("reset", false, "恢复为默认密码,下次登入后必须修改")
+	fs.Usage = showPasswdHelp
+	fs.Parse(args)
+
+	newPassword := *password
+	mustChange := *temporary
+	if *reset {
+		if newPassword != "" {
+			log.Fatalf("-reset and -password cannot be used together")
+		}
+		newPassword = config.DefaultPassword
+		mustChange = true
+	} else {
+		if newPassword == "" {
+			newPassword = readNewPassword()
+		}
+		if err := config.ValidateNewPassword(newPassword); err != nil {
+			log.Fatalf("Invalid password: %v", err)
+		}
+	}
+
+	cfg, err := config.SetPassword(strings.TrimSpace(*account), newPassword, mustChange)
+	if err != nil {
+		log.Fatalf("Failed to set password: %v", err)
+	}
+	audit.Record(audit.Entry{
+		Action: "password_change",
+		User:   "cli",
+		Detail: map[string]interface{}{"account": cfg.Account, "config_json": true, "reset": *reset, "temporary": mustChange},
+	})
+	if *reset {
+		fmt.Printf("已将用户[%s]的密码恢复为默认密码,登入后需要先修改密码\n", cfg.Account)
+	} else {
+		fmt.Printf("已修改用户[%s]的密码\n", cfg.Account)
+	}
+	fmt.Println("WebUI正在运行时,需要重启后生效")
+}
+
+// readNewPassword 从输入读取两次新密码
+func readNewPassword() string {
+	reader := bufio.NewReader(os.Stdin)
+	read := func(prompt string) string {
+		fmt.Print(prompt)
+		line, err := reader.ReadString('\n')
+		if err != nil && line == "" {
+			log.Fatalf("Failed to read password: %v", err)
+		}
+		return strings.TrimRight(line, "\r\n")
+	}
+	first := read("新密码: ")
+	if read("再次输入新密码: ") != first {
+		log.Fatalf("Passwords do not match")
+	}
+	return first
+}
+
+func showPasswdHelp() {
+	fmt.Println("重置WebUI登入密码,在程序所在目录运行,修改config.json中的密码哈希:")
+	fmt.Println("passwd  输入两次新密码")
+	fmt.Printf("passwd [-account 新用户名] [-password 新密码] [-temp]  直接设置新密码,至少%d个字符,-temp为下次登入后必须修改\n", config.MinPasswordLength)
+	fmt.Println("passwd -reset  恢复为默认密码,登入后必须先修改")
+}
+
+// runUnlockCommand 解除登入失败导致的锁定,WebUI运行时也可以使用,立即生效
+//
+//	unlock list
+//	unlock admin
+//	unlock -ip 192.168.1.10
+//	unlock -all
+func runUnlockCommand(args []string) {
+	if len(args) > 0 && args[0] == "list" {
+		records, err := lockout.List()
+		if err != nil {
+			log.Fatalf("Failed to load login failures: %v", err)
+		}
+		if len(records) == 0 {
+			fmt.Println("没有登入失败记录")
+			return
+		}
+		now := time.Now()
+		for _, r := range records {
+			state := fmt.Sprintf("失败%d次", r.Failures)
+			if r.Locked(now) {
+				state = "锁定到" + r.LockedUntil.Format(timeLayout)
+			}
+			fmt.Printf("%s %s 已锁定%d次 最近失败:%s\n", r.Key, state, r.Lockouts, r.LastFailure.Format(timeLayout))
+		}
+		return
+	}
+
+	fs := flag.NewFlagSet("unlock", flag.ExitOnError)
+	ip := fs.String("ip", "", "解除IP的锁定")
+	all := fs.Bool("all", false, "解除全部锁定")
+	fs.Usage = showUnlockHelp
+	fs.Parse(args)
+
+	var key string
+	switch {
+	case *all:
+	case *ip != "":
+		key = lockout.Key(lockout.KindIP, *ip)
+	case fs.NArg() == 1:
+		key = lockout.Key(lockout.KindAccount, fs.Arg(0))
+	default:
+		showUnlockHelp()
+		os.Exit(1)
+	}
+
+	count, err := lockout.Unlock(key)
+	if err != nil {
+		log.Fatalf("Failed to unlock: %v", err)
+	}
+	audit.Record(audit.Entry{
+		Action: "login_unlock",
+		User:   "cli",
+		Detail: map[string]interface{}{"key": key, "all": *all, "cleared": count},
+	})
+	if count == 0 {
+		fmt.Println("没有找到对应的登入失败记录")
+		return
+	}
+	fmt.Printf("已解除%d条登入失败记录\n", count)
+}
+
+func showUnlockHelp() {
+	fmt.Println("解除WebUI登入失败导致的锁定,在程序所在目录运行,WebUI运行时也立即生效:")
+	fmt.Println("unlock list  列出登入失败和锁定记录")
+	fmt.Println("unlock 用户名  解除用户名的锁定")
+	fmt.Println("unlock -ip 192.168.1.10  解除IP的锁定")
+	fmt.Println("unlock -all  解除全部锁定")
+}
@@ -11,14 +11,53 @@ import (
 // 配置文件路径
 const configFile = "config.json"
 
+// DefaultPassword 首次运行时的默认密码,使用它登入后必须先修改密码
+const DefaultPassword = "admin"
+
 type Config struct {
 	Account  string `json:"account"`  // 登入用户名
-	Password string `json:"password"` // 登入密码
+	Password string `json:"password"` // 登入密码,只用于手动设置,启动时会转换为passwordHash并清空
 	Title    string `json:"title"`    // 自定义标题
 	Port     string `json:"port"`     // WebUI端口
 	UseHttps bool   `json:"useHttps"` // 使用 https
 	Cert     string `json:"cert"`     // 证书
 	Key      string `json:"key"`      // 密钥
+
+	TLSHosts      []string `json:"tlsHosts"`      // 自动生成的自签名证书或ACME证书包含的域名和IP
+	AcmeDirectory string   `json:"acmeDirectory"` // ACME服务的目录地址,如内网的step-ca,设置后从ACME服务申请证书
+	AcmeEmail     string   `json:"acmeEmail"`     // ACME账号的联系邮箱
+	AcmeCA        string   `json:"acmeCA"`        // 访问ACME服务时信任的CA证书文件,为空时使用系统CA
+	AcmeHTTPPort  string   `json:"acmeHTTPPort"`  // 提供http-01验证的端口,通常为80,为空时只使用tls-alpn-01验证
+
+	PasswordHash       string `json:"passwordHash"`       // 登入密码的bcrypt哈希
+	MustChangePassword bool   `json:"mustChangePassword"` // 登入后必须先修改密码,使用默认或临时密码时为true
+
+	OptOutEnabled  bool     `json:"optOutEnabled"`  // 接收onebot事件上报,处理退订
+	OptOutKeywords []string `json:"optOutKeywords"` // 退订关键词,私聊或群管理员在群内发送
+	OptOutConfirm  bool     `json:"optOutConfirm"`  // 退订后回复确认消息
+	OptOutReply    string   `json:"optOutReply"`    // 确认消息内容
+	EventSecret    string   `json:"eventSecret"`    // http上报的签名密钥(secret),为空时不接收http上报
+	EventToken     string   `json:"eventToken"`     // 反向websocket的access_token,为空时不接收反向websocket
+
+	ScheduleMissedPolicy string `json:"scheduleMissedPolicy"` // 停机期间错过的定时任务: skip跳过,run启动后补发一次
+	ScheduleGraceMinutes int    `json:"scheduleGraceMinutes"` // 错过不超过这么多分钟的定时任务照常执行
+
+	ApprovalThreshold  int  `json:"approvalThreshold"`  // WebUI任务的目标数超过时需要另一个用户审批,0为不按数量审批
+	ApprovalFriendMode bool `json:"approvalFriendMode"` // WebUI的私聊模式(-f)任务总是需要另一个用户审批
+
+	LoginMaxAttempts      int `json:"loginMaxAttempts"`      // 同一用户名连续登入失败这么多次后锁定
+	LoginMaxAttemptsPerIP int `json:"loginMaxAttemptsPerIP"` // 同一IP连续登入失败这么多次后锁定
+	LoginLockoutMinutes   int `json:"loginLockoutMinutes"`   // 第一次锁定的分钟数,之后每次锁定时间翻倍,最长一天
+
+	TrustedProxies []string `json:"trustedProxies"` // 信任的反向代理IP或网段,只有来自这些地址的请求才使用X-Forwarded-For中的来源IP
+
+	HTTPTimeout            int    `json:"httpTimeout"`            // 调用onebot api的超时秒数,只支持正数,0和负数使用默认的60秒
+	HTTPCA                 string `json:"httpCA"`                 // 额外信任的CA证书文件,onebot api使用内网CA签发的证书时填写
+	HTTPClientCert         string `json:"httpClientCert"`         // 客户端证书文件,用于双向TLS
+	HTTPClientKey          string `json:"httpClientKey"`          // 客户端证书的私钥文件
+	HTTPInsecureSkipVerify bool   `json:"httpInsecureSkipVerify"` // 不验证onebot api的证书,仅用于测试环境
+	HTTPProxy              string `json:"httpProxy"`              // 代理地址,为空时使用HTTP_PROXY等环境变量
+	TokenInQuery           bool   `json:"tokenInQuery"`           // access_token放在?access_token=中,兼容不支持Authorization请求头的实现
 }
 
 type BotInfo struct {
@@ -38,14 +77,49 @@ var defaultConfig = Config{
 	Cert:     "",
 	Key:      "",
 	Account:  "admin",
-	Password: "admin",
+	Password: DefaultPassword,
 	Title:    "",
 	Port:     "60123",
+
+	TLSHosts:      []string{"localhost", "127.0.0.1", "::1"},
+	AcmeDirectory: "",
+	AcmeEmail:     "",
+	AcmeCA:        "",
+	AcmeHTTPPort:  "",
+
+	OptOutEnabled:  false,
+	OptOutKeywords: []string{"退订"},
+	OptOutConfirm:  false,
+	OptOutReply:    "已为您退订广播通知,如需恢复请联系管理员",
+	EventSecret:    "",
+	EventToken:     "",
+
+	ScheduleMissedPolicy: "skip",
+	ScheduleGraceMinutes: 5,
+
+	ApprovalThreshold:  0,
+	ApprovalFriendMode: false,
+
+	LoginMaxAttempts:      5,
+	LoginMaxAttemptsPerIP: 20,
+	LoginLockoutMinutes:   1,
+
+	TrustedProxies: []string{},
+
+	HTTPTimeout:            60,
+	HTTPCA:                 "",
+	HTTPClientCert:         "",
+	HTTPClientKey:          "",
+	HTTPInsecureSkipVerify: false,
+	HTTPProxy:              "",
+	TokenInQuery:           false,
 }
 
 // readConfig 尝试读取配置文件，如果失败则创建并自动配置默认配置
 func ReadConfig() Config {
 	var config Config
+	// 解析失败时不写回,避免覆盖用户的配置文件
+	writable := true
 
 	data, err := os.ReadFile(configFile)
 	if err != nil {
@@ -56,11 +130,19 @@ func ReadConfig() Config {
 		if err != nil {
 			fmt.Println("配置解析失败, 正在使用默认配置...")
 			config = defaultConfig
+			writable = false
 		}
 	}
 
 	// 检查并设置默认值
-	if checkAndSetDefaults(&config) {
+	modified := checkAndSetDefaults(&config)
+	// 明文密码转换为哈希
+	if hashed, err := migratePassword(&config); err != nil {
+		log.Fatalf("无法生成密码哈希: %v", err)
+	} else if hashed {
+		modified = true
+	}
+	if modified && writable {
 		// 如果配置被修改，写回文件
 		WriteConfigToFile(config)
 	}
@@ -68,6 +150,23 @@ func ReadConfig() Config {
 	return config
 }
 
+// LoadConfig 读取配置文件并补全默认值,不创建也不写回配置文件,用于命令行模式。
+// 配置文件不存在时返回默认配置
+func LoadConfig() (Config, error) {
+	var config Config
+	data, err := os.ReadFile(configFile)
+	if err != nil && !os.IsNotExist(err) {
+		return defaultConfig, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &config); err != nil {
+			return defaultConfig, fmt.Errorf("failed to parse %s: %w", configFile, err)
+		}
+	}
+	checkAndSetDefaults(&config)
+	return config, nil
+}
+
 // checkAndSetDefaults 检查并设置默认值，返回是否做了修改
 func checkAndSetDefaults(config *Config) bool {
 	// 通过反射获取Config的类型和值
@@ -91,6 +190,11 @@ func checkAndSetDefaults(config *Config) bool {
 		fieldName := typ.Field(i).Name
 
 		// 特殊处理RestartInterval字段
+		// 密码由migratePassword处理,转换为哈希后Password为空
+		if fieldName == "Password" || fieldName == "PasswordHash" {
+			continue
+		}
+
 		if fieldName == "RestartInterval" || fieldName == "WhiteCheckTime" || fieldName == "MemoryCleanupInterval" || fieldName == "BackupInterval" || fieldName == "MemoryCheckInterval" {
 			continue
 		}
@@ -0,0 +1,80 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MinPasswordLength 新密码的最小长度
+const MinPasswordLength = 8
+
+// HashPassword 返回密码的bcrypt哈希
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// CheckPassword 检查密码与哈希是否匹配
+func CheckPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// ValidateNewPassword 检查新密码是否可用
+func ValidateNewPassword(password string) error {
+	if len(password) < MinPasswordLength {
+		return fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+	}
+	if password == DefaultPassword {
+		return errors.New("password must not be the default password")
+	}
+	return nil
+}
+
+// migratePassword 把config.json中的明文密码转换为哈希,返回是否做了修改。
+// 没有设置任何密码时使用默认密码,并要求首次登入后修改
+func migratePassword(config *Config) (bool, error) {
+	if config.Password == "" && config.PasswordHash != "" {
+		return false, nil
+	}
+	password := config.Password
+	if password == "" {
+		password = DefaultPassword
+	}
+	hash, err := HashPassword(password)
+	if err != nil {
+		return false, err
+	}
+	config.PasswordHash = hash
+	config.Password = ""
+	if password == DefaultPassword {
+		config.MustChangePassword = true
+	}
+	return true, nil
+}
+
+// SetPassword 修改config.json中的登入用户名和密码,account为空时不修改用户名。
+// mustChange为true时下次登入后必须再修改密码,用于重置为临时密码
+func SetPassword(account, password string, mustChange bool) (Config, error) {
+	config, err := LoadConfig()
+	if err != nil {
+		return config, err
+	}
+
+	hash, err := HashPassword(password)
+	if err != nil {
+		return config, err
+	}
+	if account != "" {
+		config.Account = account
+	}
+	config.Password = ""
+	config.PasswordHash = hash
+	config.MustChangePassword = mustChange
+	WriteConfigToFile(config)
+	return config, nil
+}
@@ -0,0 +1,90 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+// 2026-10-16 是周五
+var base = time.Date(2026, 10, 16, 10, 0, 0, 0, time.UTC)
+
+func TestParseInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"* * * * fri-mon",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"* * * foo *",
+	}
+	for _, expr := range tests {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", expr)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	tests := []struct {
+		expr string
+		from time.Time
+		want []string
+	}{
+		{"0 22 * * thu", base, []string{"2026-10-22 22:00", "2026-10-29 22:00"}},
+		{"*/15 * * * *", base, []string{"2026-10-16 10:15", "2026-10-16 10:30"}},
+		{"5/20 10 * * *", base, []string{"2026-10-16 10:05", "2026-10-16 10:25", "2026-10-16 10:45", "2026-10-17 10:05"}},
+		{"0 9,18 * * *", base, []string{"2026-10-16 18:00", "2026-10-17 09:00"}},
+		{"30 8 1 * *", base, []string{"2026-11-01 08:30", "2026-12-01 08:30"}},
+		{"0 0 1 jan *", base, []string{"2027-01-01 00:00"}},
+		{"0 0 29 feb *", base, []string{"2028-02-29 00:00"}},
+		// 星期的范围
+		{"0 9 * * mon-fri", base, []string{"2026-10-19 09:00", "2026-10-20 09:00"}},
+		{"0 9 * * 1-5", base, []string{"2026-10-19 09:00"}},
+		// 以周日结尾的范围
+		{"0 9 * * mon-sun", base, []string{"2026-10-17 09:00", "2026-10-18 09:00", "2026-10-19 09:00"}},
+		{"0 9 * * fri-sun", base, []string{"2026-10-17 09:00", "2026-10-18 09:00", "2026-10-23 09:00"}},
+		{"0 9 * * fri-0", base, []string{"2026-10-17 09:00", "2026-10-18 09:00", "2026-10-23 09:00"}},
+		{"0 9 * * sat-7", base, []string{"2026-10-17 09:00", "2026-10-18 09:00", "2026-10-24 09:00"}},
+		{"0 9 * * sun-sat", base, []string{"2026-10-17 09:00", "2026-10-18 09:00", "2026-10-19 09:00"}},
+		// 0和7都是周日
+		{"0 9 * * 7", base, []string{"2026-10-18 09:00", "2026-10-25 09:00"}},
+		{"0 9 * * SUN", base, []string{"2026-10-18 09:00"}},
+		// 日和星期都有限制时满足其一即可
+		{"0 9 20 * mon", base, []string{"2026-10-19 09:00", "2026-10-20 09:00", "2026-10-26 09:00"}},
+		// 不含当前时间
+		{"0 10 * * *", base, []string{"2026-10-17 10:00"}},
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.expr)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.expr, err)
+			continue
+		}
+		next := tt.from
+		for _, want := range tt.want {
+			next = s.Next(next)
+			if got := next.Format("2006-01-02 15:04"); got != want {
+				t.Errorf("%q: Next = %s, want %s", tt.expr, got, want)
+				break
+			}
+		}
+	}
+}
+
+func TestNextNever(t *testing.T) {
+	s, err := Parse("0 0 30 feb *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next := s.Next(base); !next.IsZero() {
+		t.Errorf("Next = %v, want zero time", next)
+	}
+}
@@ -0,0 +1,252 @@
+package exclude
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hoshinonyaruko/gensokyo-broadcast/filelock"
+)
+
+// 排除列表文件,CLI和WebUI共用
+const excludeFile = "exclude.json"
+
+// 锁文件,CLI、WebUI和退订可能在不同进程中同时修改排除列表
+const lockFile = excludeFile + ".lock"
+
+// 排除类型
+const (
+	TypeGroup = "group" // 按群号排除
+	TypeUser  = "user"  // 按QQ号排除
+	TypeName  = "name"  // 按群名、昵称或备注的正则排除
+)
+
+var ErrEntryNotFound = errors.New("exclusion not found")
+
+// Entry 排除列表中的一条记录
+type Entry struct {
+	ID      string    `json:"id"`
+	Type    string    `json:"type"`
+	Value   string    `json:"value"`
+	Reason  string    `json:"reason"`
+	Expire  time.Time `json:"expire,omitempty"` // 零值表示永久有效
+	Created time.Time `json:"created"`
+	Source  string    `json:"source,omitempty"` // 添加来源,如cli、webui
+}
+
+// Expired 判断记录是否已过期
+func (e Entry) Expired(now time.Time) bool {
+	return !e.Expire.IsZero() && now.After(e.Expire)
+}
+
+var mu sync.Mutex
+
+// Load 读取排除列表,文件不存在时返回空列表
+func Load() ([]Entry, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	return load()
+}
+
+func load() ([]Entry, error) {
+	data, err := os.ReadFile(excludeFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", excludeFile, err)
+	}
+	return entries, nil
+}
+
+// save 先写入临时文件再替换,读取的进程不会读到写了一半的文件
+func save(entries []Entry) error {
+	data, err := json.MarshalIndent(entries, "", "    ")
+	if err != nil {
+		return err
+	}
+	tmp := excludeFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, excludeFile)
+}
+
+// update 在锁内读取排除列表并修改
+func update(fn func([]Entry) ([]Entry, error)) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	unlock, err := filelock.Lock(lockFile)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	entries, err := load()
+	if err != nil {
+		return err
+	}
+	entries, err = fn(entries)
+	if err != nil {
+		return err
+	}
+	return save(entries)
+}
+
+// Validate 检查记录的类型和值
+func (e Entry) Validate() error {
+	switch e.Type {
+	case TypeGroup, TypeUser:
+		if _, err := strconv.ParseInt(e.Value, 10, 64); err != nil {
+			return fmt.Errorf("invalid %s id %q", e.Type, e.Value)
+		}
+	case TypeName:
+		if _, err := regexp.Compile(e.Value); err != nil {
+			return fmt.Errorf("invalid name pattern %q: %w", e.Value, err)
+		}
+	default:
+		return fmt.Errorf("unknown exclusion type %q", e.Type)
+	}
+	return nil
+}
+
+// Add 添加一条记录并返回带有编号的记录
+func Add(entry Entry) (Entry, error) {
+	if err := entry.Validate(); err != nil {
+		return Entry{}, err
+	}
+
+	err := update(func(entries []Entry) ([]Entry, error) {
+		// 编号递增,删除记录后不会复用
+		maxID := 0
+		for _, e := range entries {
+			if id, err := strconv.Atoi(e.ID); err == nil && id > maxID {
+				maxID = id
+			}
+		}
+		entry.ID = strconv.Itoa(maxID + 1)
+		if entry.Created.IsZero() {
+			entry.Created = time.Now()
+		}
+		return append(entries, entry), nil
+	})
+	if err != nil {
+		return Entry{}, err
+	}
+	return entry, nil
+}
+
+// Remove 按编号删除记录
+func Remove(id string) error {
+	return update(func(entries []Entry) ([]Entry, error) {
+		for i, e := range entries {
+			if e.ID == id {
+				return append(entries[:i], entries[i+1:]...), nil
+			}
+		}
+		return nil, ErrEntryNotFound
+	})
+}
+
+// List 用于在发送任务中检查目标,只包含未过期的记录
+type List struct {
+	entries  []Entry
+	patterns map[string]*regexp.Regexp
+	stamp    fileStamp // 读取时exclude.json的状态
+}
+
+// fileStamp 用修改时间和大小判断文件是否被修改
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func currentStamp() fileStamp {
+	info, err := os.Stat(excludeFile)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{modTime: info.ModTime(), size: info.Size()}
+}
+
+// Active 读取排除列表中未过期的记录
+func Active() (*List, error) {
+	// 先记录文件状态再读取,读取期间的修改会在下次Changed时发现
+	stamp := currentStamp()
+	entries, err := Load()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	list := &List{patterns: make(map[string]*regexp.Regexp), stamp: stamp}
+	for _, e := range entries {
+		if e.Expired(now) {
+			continue
+		}
+		if e.Type == TypeName {
+			re, err := regexp.Compile(e.Value)
+			if err != nil {
+				continue
+			}
+			list.patterns[e.ID] = re
+		}
+		list.entries = append(list.entries, e)
+	}
+	return list, nil
+}
+
+// Changed 判断读取之后exclude.json是否被修改,长时间运行的任务据此重新读取
+func (l *List) Changed() bool {
+	return currentStamp() != l.stamp
+}
+
+// HasNamePatterns 是否包含按名称排除的记录,此时需要目标的名称才能判断
+func (l *List) HasNamePatterns() bool {
+	return len(l.patterns) > 0
+}
+
+// Len 返回有效记录的数量
+func (l *List) Len() int {
+	return len(l.entries)
+}
+
+// Match 检查目标是否被排除,isfriend表示目标是好友,names是群名或昵称、备注
+func (l *List) Match(targetID int64, isfriend bool, names ...string) (Entry, bool) {
+	idStr := strconv.FormatInt(targetID, 10)
+	now := time.Now()
+	for _, e := range l.entries {
+		// 任务运行期间到期的记录不再生效
+		if e.Expired(now) {
+			continue
+		}
+		switch e.Type {
+		case TypeGroup:
+			if !isfriend && e.Value == idStr {
+				return e, true
+			}
+		case TypeUser:
+			if isfriend && e.Value == idStr {
+				return e, true
+			}
+		case TypeName:
+			re := l.patterns[e.ID]
+			for _, name := range names {
+				if name != "" && re.MatchString(name) {
+					return e, true
+				}
+			}
+		}
+	}
+	return Entry{}, false
+}
@@ -0,0 +1,227 @@
+package exclude
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hoshinonyaruko/gensokyo-broadcast/filelock"
+)
+
+// inTempDir 切换到临时目录,排除列表写在当前目录下
+func inTempDir(t *testing.T) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		entry   Entry
+		wantErr bool
+	}{
+		{Entry{Type: TypeGroup, Value: "123456"}, false},
+		{Entry{Type: TypeUser, Value: "10001"}, false},
+		{Entry{Type: TypeName, Value: "^测试"}, false},
+		{Entry{Type: TypeGroup, Value: ""}, true},
+		{Entry{Type: TypeGroup, Value: "abc"}, true},
+		{Entry{Type: TypeUser, Value: "1.5"}, true},
+		{Entry{Type: TypeName, Value: "["}, true},
+		{Entry{Type: "channel", Value: "1"}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.entry.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%+v Validate = %v, wantErr %v", tt.entry, err, tt.wantErr)
+		}
+	}
+}
+
+func TestAddRemove(t *testing.T) {
+	inTempDir(t)
+	for _, value := range []string{"1", "2", "3"} {
+		if _, err := Add(Entry{Type: TypeGroup, Value: value}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := Add(Entry{Type: TypeGroup, Value: "x"}); err == nil {
+		t.Error("Add accepted an invalid entry")
+	}
+	if err := Remove("3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Remove("3"); !errors.Is(err, ErrEntryNotFound) {
+		t.Errorf("Remove missing entry = %v, want ErrEntryNotFound", err)
+	}
+
+	// 删除后编号不复用
+	e, err := Add(Entry{Type: TypeUser, Value: "4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.ID != "3" {
+		t.Errorf("new ID = %s, want 3", e.ID)
+	}
+	if err := Remove("2"); err != nil {
+		t.Fatal(err)
+	}
+	e, _ = Add(Entry{Type: TypeUser, Value: "5"})
+	if e.ID != "4" || e.Created.IsZero() {
+		t.Errorf("new entry = %+v, want ID 4 with created time", e)
+	}
+
+	entries, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, e := range entries {
+		ids = append(ids, e.ID+":"+e.Value)
+	}
+	if got := strings.Join(ids, " "); got != "1:1 3:4 4:5" {
+		t.Errorf("entries = %s, want 1:1 3:4 4:5", got)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	inTempDir(t)
+	now := time.Now()
+	for _, e := range []Entry{
+		{Type: TypeGroup, Value: "100"},
+		{Type: TypeUser, Value: "200"},
+		{Type: TypeName, Value: "^测试"},
+		{Type: TypeGroup, Value: "300", Expire: now.Add(-time.Minute)},
+		{Type: TypeGroup, Value: "400", Expire: now.Add(time.Hour)},
+	} {
+		if _, err := Add(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	list, err := Active()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list.Len() != 4 || !list.HasNamePatterns() {
+		t.Errorf("Len = %d, HasNamePatterns = %v, want 4, true", list.Len(), list.HasNamePatterns())
+	}
+
+	tests := []struct {
+		id       int64
+		isfriend bool
+		names    []string
+		wantID   string
+	}{
+		{100, false, nil, "1"},
+		// 群号规则不匹配同号的好友,QQ号规则不匹配同号的群
+		{100, true, nil, ""},
+		{200, true, nil, "2"},
+		{200, false, nil, ""},
+		{500, false, []string{"测试群"}, "3"},
+		{500, true, []string{"", "测试备注"}, "3"},
+		{500, false, []string{"正式群"}, ""},
+		// 已过期的记录不生效
+		{300, false, nil, ""},
+		{400, false, nil, "5"},
+	}
+	for _, tt := range tests {
+		e, ok := list.Match(tt.id, tt.isfriend, tt.names...)
+		if ok != (tt.wantID != "") || e.ID != tt.wantID {
+			t.Errorf("Match(%d, %v, %q) = %q, %v, want %q", tt.id, tt.isfriend, tt.names, e.ID, ok, tt.wantID)
+		}
+	}
+}
+
+func TestChanged(t *testing.T) {
+	inTempDir(t)
+	list, err := Active()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list.Changed() {
+		t.Error("Changed before any write")
+	}
+	if _, err := Add(Entry{Type: TypeGroup, Value: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if !list.Changed() {
+		t.Error("Changed = false after Add")
+	}
+	list, _ = Active()
+	if list.Changed() {
+		t.Error("Changed right after reload")
+	}
+	if _, ok := list.Match(1, false); !ok {
+		t.Error("reloaded list does not contain the new entry")
+	}
+}
+
+func TestConcurrentAdd(t *testing.T) {
+	inTempDir(t)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := Add(Entry{Type: TypeGroup, Value: "1"}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	entries, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for _, e := range entries {
+		seen[e.ID] = true
+	}
+	if len(entries) != 20 || len(seen) != 20 {
+		t.Errorf("got %d entries with %d distinct IDs, want 20", len(entries), len(seen))
+	}
+}
+
+func TestLockFile(t *testing.T) {
+	inTempDir(t)
+	// 其他进程持有锁时等待锁释放
+	if err := os.WriteFile(lockFile, []byte("1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	released := make(chan struct{})
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		close(released)
+		os.Remove(lockFile)
+	}()
+	if _, err := Add(Entry{Type: TypeGroup, Value: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-released:
+	default:
+		t.Error("Add did not wait for the lock")
+	}
+	if _, err := os.Stat(lockFile); !os.IsNotExist(err) {
+		t.Error("lock file was not removed after Add")
+	}
+
+	// 残留的旧锁文件被清理
+	if err := os.WriteFile(lockFile, []byte("1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * filelock.Stale)
+	if err := os.Chtimes(lockFile, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Add(Entry{Type: TypeGroup, Value: "2"}); err != nil {
+		t.Fatalf("Add with stale lock: %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+package filelock
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	retry   = 20 * time.Millisecond
+	timeout = 10 * time.Second
+	// Stale 超过这个时间的锁文件视为进程异常退出后残留的
+	Stale = time.Minute
+)
+
+var ErrTimeout = errors.New("timed out waiting for lock file")
+
+// Lock 创建锁文件path,用于CLI和WebUI等多个进程读取-修改-写入同一个文件。
+// 锁文件已存在时等待其他进程释放,返回释放锁的函数
+func Lock(path string) (func(), error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			fmt.Fprintf(f, "%d\n", os.Getpid())
+			f.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > Stale {
+			os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%w %s", ErrTimeout, path)
+		}
+		time.Sleep(retry)
+	}
+}
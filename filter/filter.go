@@ -0,0 +1,188 @@
+package filter
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 支持的比较运算符,按长度排列,保证 >= 先于 > 匹配
+var operators = []string{">=", "<=", "!=", "==", "!~", ">", "<", "=", "~"}
+
+// condition 单个过滤条件,如 member_count>=50
+type condition struct {
+	field string
+	op    string
+	value string
+	num   float64 // value可解析为数字或日期时的数值
+	isNum bool
+	re    *regexp.Regexp // ~ 和 !~ 使用的正则
+}
+
+// Filter 由多个以 && 连接的条件组成,全部满足时匹配
+type Filter struct {
+	expr  string
+	conds []condition
+}
+
+// Parse 解析过滤表达式,如 "member_count>=50 && group_name~^测试"
+// 数值字段可以和日期比较,日期格式为2006-01-02,按本地时间转换为unix时间戳
+func Parse(expr string) (*Filter, error) {
+	f := &Filter{expr: expr}
+	for _, part := range strings.Split(expr, "&&") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		cond, err := parseCondition(part)
+		if err != nil {
+			return nil, err
+		}
+		f.conds = append(f.conds, cond)
+	}
+	if len(f.conds) == 0 {
+		return nil, fmt.Errorf("empty filter expression")
+	}
+	return f, nil
+}
+
+func parseCondition(part string) (condition, error) {
+	// 找到最靠前的运算符
+	pos, op := -1, ""
+	for _, candidate := range operators {
+		if i := strings.Index(part, candidate); i >= 0 && (pos < 0 || i < pos || (i == pos && len(candidate) > len(op))) {
+			pos, op = i, candidate
+		}
+	}
+	if pos <= 0 {
+		return condition{}, fmt.Errorf("invalid filter condition %q", part)
+	}
+
+	cond := condition{
+		field: strings.TrimSpace(part[:pos]),
+		op:    op,
+		value: strings.TrimSpace(part[pos+len(op):]),
+	}
+	if cond.op == "==" {
+		cond.op = "="
+	}
+
+	switch cond.op {
+	case "~", "!~":
+		re, err := regexp.Compile(cond.value)
+		if err != nil {
+			return condition{}, fmt.Errorf("invalid regexp in %q: %w", part, err)
+		}
+		cond.re = re
+	default:
+		if n, err := strconv.ParseFloat(cond.value, 64); err == nil {
+			cond.num, cond.isNum = n, true
+		} else if t, err := time.ParseInLocation("2006-01-02", cond.value, time.Local); err == nil {
+			cond.num, cond.isNum = float64(t.Unix()), true
+		}
+	}
+	return cond, nil
+}
+
+// String 返回原始表达式
+func (f *Filter) String() string {
+	return f.expr
+}
+
+// Check 检查表达式中的字段是否都存在于sample对应的结构体中
+func (f *Filter) Check(sample interface{}) error {
+	typ := reflect.Indirect(reflect.ValueOf(sample)).Type()
+	for _, cond := range f.conds {
+		if _, ok := fieldIndex(typ, cond.field); !ok {
+			return fmt.Errorf("unknown filter field %q", cond.field)
+		}
+	}
+	return nil
+}
+
+// Match 判断结构体v是否满足全部条件,字段名使用json标签
+func (f *Filter) Match(v interface{}) (bool, error) {
+	val := reflect.Indirect(reflect.ValueOf(v))
+	for _, cond := range f.conds {
+		index, ok := fieldIndex(val.Type(), cond.field)
+		if !ok {
+			return false, fmt.Errorf("unknown filter field %q", cond.field)
+		}
+		matched, err := cond.match(val.Field(index))
+		if err != nil {
+			return false, err
+		}
+		if !matched {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// fieldIndex 根据json标签查找结构体字段
+func fieldIndex(typ reflect.Type, name string) (int, bool) {
+	for i := 0; i < typ.NumField(); i++ {
+		tag := strings.Split(typ.Field(i).Tag.Get("json"), ",")[0]
+		if tag == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func (c condition) match(field reflect.Value) (bool, error) {
+	if c.re != nil {
+		matched := c.re.MatchString(fmt.Sprint(field.Interface()))
+		return matched == (c.op == "~"), nil
+	}
+
+	switch field.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if !c.isNum {
+			return false, fmt.Errorf("field %s needs a number or date, got %q", c.field, c.value)
+		}
+		return compare(float64(field.Int()), c.num, c.op), nil
+	case reflect.Float32, reflect.Float64:
+		if !c.isNum {
+			return false, fmt.Errorf("field %s needs a number, got %q", c.field, c.value)
+		}
+		return compare(field.Float(), c.num, c.op), nil
+	case reflect.String:
+		s := field.String()
+		// 数字形式的字符串字段(如user_id)按数值比较
+		if n, err := strconv.ParseFloat(s, 64); err == nil && c.isNum {
+			return compare(n, c.num, c.op), nil
+		}
+		switch c.op {
+		case "=":
+			return s == c.value, nil
+		case "!=":
+			return s != c.value, nil
+		default:
+			return false, fmt.Errorf("operator %s is not supported for text field %s", c.op, c.field)
+		}
+	default:
+		return false, fmt.Errorf("field %s cannot be filtered", c.field)
+	}
+}
+
+func compare(a, b float64, op string) bool {
+	switch op {
+	case ">=":
+		return a >= b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	case "<":
+		return a < b
+	case "=":
+		return a == b
+	case "!=":
+		return a != b
+	}
+	return false
+}
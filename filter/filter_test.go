@@ -0,0 +1,147 @@
+package filter
+
+import (
+	"testing"
+	"time"
+)
+
+type group struct {
+	GroupID        int64   `json:"group_id"`
+	GroupName      string  `json:"group_name"`
+	MemberCount    int32   `json:"member_count"`
+	CreateTime     int64   `json:"group_create_time"`
+	Score          float64 `json:"score"`
+	UserID         string  `json:"user_id"`
+	Owner          bool    `json:"owner"`
+	MaxMemberCount int32   `json:"max_member_count,omitempty"`
+}
+
+var sample = group{
+	GroupID:        123456,
+	GroupName:      "测试群 01",
+	MemberCount:    50,
+	CreateTime:     time.Date(2024, 6, 1, 12, 0, 0, 0, time.Local).Unix(),
+	Score:          4.5,
+	UserID:         "10001",
+	MaxMemberCount: 200,
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		" && ",
+		"member_count",
+		">=50",
+		"group_name~[",
+	}
+	for _, expr := range tests {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", expr)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{"member_count>=50", true},
+		{"member_count>50", false},
+		{"member_count<=50", true},
+		{"member_count<50", false},
+		{"member_count=50", true},
+		{"member_count==50", true},
+		{"member_count!=50", false},
+		{" member_count >= 10 ", true},
+		{"max_member_count=200", true},
+		{"score>4", true},
+		{"score<4.5", false},
+		// 数值字段和日期比较
+		{"group_create_time>=2024-06-01", true},
+		{"group_create_time<2024-06-01", false},
+		{"group_create_time<2024-06-02", true},
+		// 正则
+		{"group_name~^测试", true},
+		{"group_name!~^测试", false},
+		{"group_name~01$", true},
+		{"group_id~^123", true},
+		// 文本字段
+		{"group_name=测试群 01", true},
+		{"group_name!=测试群 01", false},
+		// 数字形式的文本字段按数值比较
+		{"user_id>10000", true},
+		{"user_id=10001", true},
+		// 全部满足时匹配
+		{"member_count>=50 && group_name~^测试", true},
+		{"member_count>=50 && group_name~^正式", false},
+		{"member_count>=10 && && score>1", true},
+	}
+	for _, tt := range tests {
+		f, err := Parse(tt.expr)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.expr, err)
+			continue
+		}
+		got, err := f.Match(sample)
+		if err != nil {
+			t.Errorf("%q Match: %v", tt.expr, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q Match = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestMatchPointer(t *testing.T) {
+	f, err := Parse("member_count>=50")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := f.Match(&sample); err != nil || !got {
+		t.Errorf("Match(&sample) = %v, %v, want true", got, err)
+	}
+}
+
+func TestMatchInvalid(t *testing.T) {
+	tests := []string{
+		"unknown>1",
+		"member_count>abc",
+		"score>abc",
+		"group_name>abc",
+		"owner=true",
+	}
+	for _, expr := range tests {
+		f, err := Parse(expr)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", expr, err)
+			continue
+		}
+		if got, err := f.Match(sample); err == nil {
+			t.Errorf("%q Match = %v, want error", expr, got)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr bool
+	}{
+		{"member_count>=50 && group_name~^测试", false},
+		{"max_member_count>1", false},
+		{"member_count>=50 && nickname~a", true},
+		{"GroupName=a", true},
+	}
+	for _, tt := range tests {
+		f, err := Parse(tt.expr)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.expr, err)
+			continue
+		}
+		if err := f.Check(group{}); (err != nil) != tt.wantErr {
+			t.Errorf("%q Check = %v, wantErr %v", tt.expr, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+)
+
+// ForwardNode 合并转发消息中的一个节点
+type ForwardNode struct {
+	Name    string `json:"name"`    // 显示的昵称
+	Uin     string `json:"uin"`     // 显示的头像对应的QQ号
+	Content string `json:"content"` // 节点内容,支持CQ码和消息模板
+}
+
+var (
+	forwardNodes   = make(map[string][]ForwardNode)
+	forwardNodesMu sync.Mutex
+)
+
+// loadForwardNodes 从json文件读取合并转发节点,同一文件只解析一次
+func loadForwardNodes(path string) ([]ForwardNode, error) {
+	forwardNodesMu.Lock()
+	defer forwardNodesMu.Unlock()
+
+	if nodes, ok := forwardNodes[path]; ok {
+		return nodes, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read forward message file: %w", err)
+	}
+
+	var nodes []ForwardNode
+	if err := json.Unmarshal(data, &nodes); err != nil {
+		return nil, fmt.Errorf("failed to parse forward message file: %w", err)
+	}
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("forward message file %s has no nodes", path)
+	}
+	for i, node := range nodes {
+		if node.Name == "" || node.Uin == "" || node.Content == "" {
+			return nil, fmt.Errorf("node %d in %s must have name, uin and content", i+1, path)
+		}
+	}
+
+	forwardNodes[path] = nodes
+	return nodes, nil
+}
+
+// buildForwardMessages 渲染每个节点的内容,构造onebot的node消息段数组
+func buildForwardMessages(nodes []ForwardNode, targetID int64) ([]map[string]interface{}, error) {
+	messages := make([]map[string]interface{}, 0, len(nodes))
+	for _, node := range nodes {
+		content, err := renderMessage(node.Content, targetID)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, map[string]interface{}{
+			"type": "node",
+			"data": map[string]interface{}{
+				"name":    node.Name,
+				"uin":     node.Uin,
+				"content": normalizeNewlines(content),
+			},
+		})
+	}
+	return messages, nil
+}
+
+// sendForwardMessage 向群或好友发送合并转发消息
+func sendForwardMessage(apiURL string, targetID int64, isfriend bool, path string, token string) (string, error) {
+	nodes, err := loadForwardNodes(path)
+	if err != nil {
+		return "", err
+	}
+	messages, err := buildForwardMessages(nodes, targetID)
+	if err != nil {
+		return "", err
+	}
+
+	if isfriend {
+		return postAction(apiURL, "send_private_forward_msg", map[string]interface{}{
+			"user_id":  targetID,
+			"messages": messages,
+		}, token)
+	}
+	return postAction(apiURL, "send_group_forward_msg", map[string]interface{}{
+		"group_id": targetID,
+		"messages": messages,
+	}, token)
+}
+
+// checkForwardFile 在任务开始前检查合并转发文件,避免发送到一半才发现格式错误
+func checkForwardFile(path string) {
+	nodes, err := loadForwardNodes(path)
+	if err != nil {
+		log.Fatalf("Error loading forward message file: %v", err)
+	}
+	fmt.Printf("从文件'%s'读取了%d个合并转发节点\n", path, len(nodes))
+}
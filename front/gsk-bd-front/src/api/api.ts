@@ -13,6 +13,30 @@ export interface LoginStatusResponse {
    */
   isLoggedIn: boolean;
 
+  /**
+   * 还在使用默认或初始密码，需要先修改密码才能使用其他接口
+   *
+   * @type {boolean}
+   * @memberof LoginStatusResponse
+   */
+  mustChangePassword?: boolean;
+
+  /**
+   * 当前用户名
+   *
+   * @type {string}
+   * @memberof LoginStatusResponse
+   */
+  user?: string;
+
+  /**
+   * 当前用户的角色 viewer/operator/admin
+   *
+   * @type {string}
+   * @memberof LoginStatusResponse
+   */
+  role?: string;
+
   /**
    * Error message if there's any issue.
    *
@@ -22,6 +46,29 @@ export interface LoginStatusResponse {
   error?: string;
 }
 
+/**
+ *
+ * @export
+ * @interface ChangePasswordResponse
+ */
+export interface ChangePasswordResponse {
+  /**
+   * 修改成功时的提示
+   *
+   * @type {string}
+   * @memberof ChangePasswordResponse
+   */
+  message?: string;
+
+  /**
+   * Error message if there's any issue.
+   *
+   * @type {string}
+   * @memberof ChangePasswordResponse
+   */
+  error?: string;
+}
+
 /**
  *
  * @export
@@ -34,6 +81,46 @@ export interface LoginResponse {
    * @memberof LoginResponse
    */
   isLoggedIn: boolean;
+
+  /**
+   * 防CSRF令牌，同时保存在 XSRF-TOKEN cookie 中，会话 cookie 不在响应中返回
+   *
+   * @type {string}
+   * @memberof LoginResponse
+   */
+  csrfToken?: string;
+
+  /**
+   * 使用默认密码登入时需要先修改密码
+   *
+   * @type {boolean}
+   * @memberof LoginResponse
+   */
+  mustChangePassword?: boolean;
+
+  /**
+   * 当前用户名
+   *
+   * @type {string}
+   * @memberof LoginResponse
+   */
+  user?: string;
+
+  /**
+   * 当前用户的角色 viewer/operator/admin
+   *
+   * @type {string}
+   * @memberof LoginResponse
+   */
+  role?: string;
+
+  /**
+   * Error message if there's any issue.
+   *
+   * @type {string}
+   * @memberof LoginResponse
+   */
+  error?: string;
 }
 
 /**
@@ -209,6 +296,30 @@ class Api {
       throw error;
     }
   }
+
+  /**
+   * 修改当前用户的密码，需要提供当前密码。新密码至少8位，且不能是默认密码
+   * 失败时返回的 error 为服务器的错误信息
+   */
+  public async changePassword(
+    oldPassword: string,
+    newPassword: string
+  ): Promise<ChangePasswordResponse> {
+    try {
+      const response: AxiosResponse<ChangePasswordResponse> =
+        await this.axiosInstance.post('/webui/api/change-password', {
+          oldPassword,
+          newPassword,
+        });
+      return response.data;
+    } catch (error) {
+      if (axios.isAxiosError(error) && error.response?.data) {
+        return error.response.data as ChangePasswordResponse;
+      }
+      console.error('Error changing password:', error);
+      throw error;
+    }
+  }
 }
 
 const api = new Api();
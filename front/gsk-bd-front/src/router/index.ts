@@ -6,7 +6,7 @@ import {
   createWebHistory,
 } from 'vue-router';
 import routes from './routes';
-import api from '../api/api';
+import api, { LoginStatusResponse } from '../api/api';
 
 export default route(function (/* { store, ssrContext } */) {
   const createHistory = process.env.SERVER
@@ -23,18 +23,18 @@ export default route(function (/* { store, ssrContext } */) {
     ),
   });
 
-  const isLoggedIn = async () => {
+  const loginStatus = async (): Promise<LoginStatusResponse> => {
     try {
-      const response = await api.checkLoginStatus();
-      return response.isLoggedIn;
+      return await api.checkLoginStatus();
     } catch (error) {
       console.error('Error checking login status:', error);
-      return false; // 如果发生错误，则默认为未登录
+      return { isLoggedIn: false }; // 如果发生错误，则默认为未登录
     }
   };
 
   Router.beforeEach(async (to, from, next) => {
-    const loggedIn = await isLoggedIn();
+    const status = await loginStatus();
+    const loggedIn = status.isLoggedIn;
     // 测试环境下假设用户始终已登录
     //const loggedIn = true;
     if (!loggedIn) {
@@ -46,6 +46,13 @@ export default route(function (/* { store, ssrContext } */) {
         // 允许访问登录页
         next();
       }
+    } else if (status.mustChangePassword) {
+      // 还在使用默认或初始密码，修改密码前只能访问修改密码页
+      if (to.name !== 'change-password') {
+        next({ name: 'change-password' });
+      } else {
+        next();
+      }
     } else {
       // 用户已登录
       if (to.name === 'login') {
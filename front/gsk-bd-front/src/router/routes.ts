@@ -36,6 +36,11 @@ const routes: RouteRecordRaw[] = [
         path: '/index',
         component: () => import('pages/IndexView.vue'),
       },
+      {
+        path: '/change-password',
+        name: 'change-password',
+        component: () => import('pages/ChangePasswordView.vue'),
+      },
       // 添加新的路由
     ],
   },
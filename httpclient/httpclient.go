@@ -0,0 +1,133 @@
+package httpclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// 未调用Configure或没有设置超时时的超时
+const defaultTimeout = 60 * time.Second
+
+// Options 调用onebot api的HTTP客户端设置
+type Options struct {
+	Timeout            time.Duration // 单次请求的超时,不大于0时使用默认的60秒,不支持不限制超时
+	CAFile             string        // 额外信任的CA证书文件,与系统CA一起使用
+	CertFile           string        // 客户端证书文件,用于双向TLS
+	KeyFile            string        // 客户端证书的私钥文件
+	InsecureSkipVerify bool          // 不验证服务器证书,仅用于测试环境
+	Proxy              string        // 代理地址,为空时使用HTTP_PROXY等环境变量
+	TokenInQuery       bool          // access_token放在查询参数中,兼容不支持Authorization请求头的实现
+}
+
+var (
+	mu           sync.RWMutex
+	client       = &http.Client{Timeout: defaultTimeout}
+	tokenInQuery bool
+)
+
+// Configure 按opts创建共用的HTTP客户端,证书或代理设置无效时返回错误
+func Configure(opts Options) error {
+	tlsConfig := &tls.Config{InsecureSkipVerify: opts.InsecureSkipVerify}
+	if opts.CAFile != "" {
+		pemData, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pemData) {
+			return fmt.Errorf("no certificate found in %s", opts.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if opts.CertFile != "" || opts.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	if opts.Proxy != "" {
+		proxyURL, err := url.Parse(opts.Proxy)
+		if err != nil || proxyURL.Host == "" {
+			return fmt.Errorf("invalid proxy %q", opts.Proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	client = &http.Client{Timeout: timeout, Transport: transport}
+	tokenInQuery = opts.TokenInQuery
+	return nil
+}
+
+// Client 返回共用的HTTP客户端
+func Client() *http.Client {
+	mu.RLock()
+	defer mu.RUnlock()
+	return client
+}
+
+// NewRequest 创建调用onebot api的请求,token默认通过 Authorization: Bearer 请求头发送,
+// 不会出现在url中,避免被日志和代理记录
+func NewRequest(method, rawURL, token string, body io.Reader) (*http.Request, error) {
+	mu.RLock()
+	inQuery := tokenInQuery
+	mu.RUnlock()
+
+	if token != "" && inQuery {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return nil, err
+		}
+		query := u.Query()
+		query.Set("access_token", token)
+		u.RawQuery = query.Encode()
+		rawURL = u.String()
+	}
+	req, err := http.NewRequest(method, rawURL, body)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" && !inQuery {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req, nil
+}
+
+// Get 以GET方式调用onebot api
+func Get(rawURL, token string) (*http.Response, error) {
+	req, err := NewRequest(http.MethodGet, rawURL, token, nil)
+	if err != nil {
+		return nil, err
+	}
+	return Client().Do(req)
+}
+
+// Post 以POST方式调用onebot api
+func Post(rawURL, token, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := NewRequest(http.MethodPost, rawURL, token, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	return Client().Do(req)
+}
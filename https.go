@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/hoshinonyaruko/gensokyo-broadcast/config"
+	"github.com/hoshinonyaruko/gensokyo-broadcast/tlscert"
+)
+
+// 未配置证书时使用的证书和私钥文件,不存在时自动生成自签名证书
+const (
+	defaultCertFile = "cert.pem"
+	defaultKeyFile  = "key.pem"
+)
+
+// httpsConfig 返回WebUI使用的tls配置。设置了acmeDirectory时从ACME服务申请证书;
+// 否则使用配置的证书,未配置时使用cert.pem和key.pem,不存在或已过期时自动生成自签名证书。
+// 证书文件被修改或收到SIGHUP时重新读取,不需要重启
+func httpsConfig(jsonconfig config.Config) (*tls.Config, error) {
+	if jsonconfig.AcmeDirectory != "" {
+		manager, err := tlscert.NewACMEManager(jsonconfig.AcmeDirectory, jsonconfig.AcmeEmail, jsonconfig.AcmeCA, jsonconfig.TLSHosts)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Printf("从ACME服务%v申请证书,域名%v\n", jsonconfig.AcmeDirectory, strings.Join(jsonconfig.TLSHosts, ","))
+		// TLSConfig只支持tls-alpn-01验证,ACME服务需要通过443端口访问。
+		// 设置了acmeHTTPPort时同时在该端口提供http-01验证,WebUI不在443端口时也能申请证书,其他请求跳转到https
+		if jsonconfig.AcmeHTTPPort != "" {
+			go func() {
+				if err := http.ListenAndServe(":"+jsonconfig.AcmeHTTPPort, manager.HTTPHandler(nil)); err != nil {
+					log.Printf("ACME http-01 challenge server on port %s failed, only tls-alpn-01 on port 443 works: %v", jsonconfig.AcmeHTTPPort, err)
+				}
+			}()
+		}
+		return manager.TLSConfig(), nil
+	}
+
+	certFile, keyFile := defaultCertFile, defaultKeyFile
+	selfSigned := jsonconfig.Cert == "" || jsonconfig.Key == ""
+	if !selfSigned {
+		certFile, keyFile = jsonconfig.Cert, jsonconfig.Key
+	}
+	ensure := func() error {
+		if !selfSigned {
+			return nil
+		}
+		generated, err := tlscert.EnsureSelfSigned(certFile, keyFile, jsonconfig.TLSHosts)
+		if generated {
+			fmt.Printf("已生成自签名证书%v,包含%v,浏览器会提示证书不受信任\n", certFile, strings.Join(jsonconfig.TLSHosts, ","))
+		}
+		return err
+	}
+	if err := ensure(); err != nil {
+		return nil, err
+	}
+	reloader, err := tlscert.NewReloader(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	reload := func() {
+		err := ensure()
+		if err == nil {
+			err = reloader.Reload()
+		}
+		if err != nil {
+			log.Printf("Failed to reload certificate, keeping the old one: %v", err)
+			return
+		}
+		log.Printf("Reloaded certificate %s", certFile)
+	}
+
+	// 证书文件修改后自动重新读取,Windows上没有SIGHUP也可以更换证书
+	if err := tlscert.WatchFiles([]string{certFile, keyFile}, reload); err != nil {
+		log.Printf("Failed to watch certificate files, use SIGHUP to reload: %v", err)
+	}
+
+	// 也可以发送SIGHUP重新读取,如 kill -HUP 进程号
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			reload()
+		}
+	}()
+	return &tls.Config{GetCertificate: reloader.GetCertificate}, nil
+}
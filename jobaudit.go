@@ -0,0 +1,91 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/hoshinonyaruko/gensokyo-broadcast/audit"
+)
+
+// 发送任务结束的状态
+const (
+	JobDone      = "done"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// jobAudit 记录发送任务的开始和结束,为nil时不记录
+type jobAudit struct {
+	launcher audit.Launcher
+	launched bool // 由WebUI启动,job_start已由WebUI记录
+	save     string
+	started  time.Time
+}
+
+// startJobAudit 记录命令行启动的发送任务,WebUI启动的任务只记录结束
+func startJobAudit(args CommandLineArgs, targets int) *jobAudit {
+	j := &jobAudit{save: args.SaveFilePath, started: time.Now()}
+	j.launcher, j.launched = audit.LauncherFromEnv()
+	if !j.launched {
+		j.launcher = audit.Launcher{User: "cli", JobID: audit.NewJobID()}
+		audit.Record(audit.Entry{
+			Time:   j.started,
+			Action: "job_start",
+			User:   j.launcher.User,
+			Detail: map[string]interface{}{
+				"source":  "cli",
+				"job":     j.launcher.JobID,
+				"save":    j.save,
+				"targets": targets,
+				"args":    audit.RedactArgs(os.Args[1:]),
+			},
+		})
+	}
+	return j
+}
+
+// end 记录任务结束,status为done、failed或cancelled
+func (j *jobAudit) end(status string, reason string) {
+	if j == nil {
+		return
+	}
+	detail := map[string]interface{}{
+		"job":      j.launcher.JobID,
+		"save":     j.save,
+		"status":   status,
+		"duration": time.Since(j.started).Round(time.Second).String(),
+	}
+	if reason != "" {
+		detail["reason"] = reason
+	}
+	audit.Record(audit.Entry{
+		Action: "job_end",
+		User:   j.launcher.User,
+		IP:     j.launcher.IP,
+		Detail: detail,
+	})
+}
+
+// watchCancel 在任务被中断(Ctrl+C、关闭窗口或kill)时记录cancelled后退出,返回停止监听的函数
+func (j *jobAudit) watchCancel() func() {
+	if j == nil {
+		return func() {}
+	}
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-sigChan:
+			j.end(JobCancelled, sig.String())
+			os.Exit(1)
+		case <-done:
+		}
+	}()
+	return func() {
+		signal.Stop(sigChan)
+		close(done)
+	}
+}
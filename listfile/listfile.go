@@ -0,0 +1,125 @@
+package listfile
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// 单个目标可以覆盖的任务设置
+const (
+	KeyMessage = "msg"   // msg=2 使用-w中的第2条消息
+	KeyDelay   = "delay" // delay=30 发送后等待30秒
+	KeyBot     = "bot"   // bot=http://127.0.0.1:8081 使用另一个机器人的API发送
+)
+
+// Target 列表文件中的一行,如 123456 #vip #cn msg=2 delay=30 # 备注
+type Target struct {
+	ID      int64
+	Tags    []string
+	Message int    // 使用第几条消息,从1开始,0表示随机选择
+	Delay   int    // 发送后的间隔秒数,-1表示使用任务设置
+	Bot     string // 发送使用的API地址,为空表示使用任务设置
+}
+
+// ParseLine 解析列表文件的一行。空行和以#开头的注释行返回false。
+// ID之后以#开头的词为标签,key=value为覆盖设置,单独的#之后为注释
+func ParseLine(line string) (Target, bool, error) {
+	line = strings.TrimSpace(strings.TrimPrefix(line, "\ufeff"))
+	if line == "" || strings.HasPrefix(line, "#") {
+		return Target{}, false, nil
+	}
+
+	fields := strings.Fields(line)
+	id, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return Target{}, false, fmt.Errorf("invalid target id %q", fields[0])
+	}
+	target := Target{ID: id, Delay: -1}
+
+	for _, field := range fields[1:] {
+		if field == "#" {
+			break
+		}
+		if strings.HasPrefix(field, "#") {
+			target.Tags = appendTag(target.Tags, strings.TrimPrefix(field, "#"))
+			continue
+		}
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return Target{}, false, fmt.Errorf("target %d: unexpected %q, tags start with # and overrides are key=value", id, field)
+		}
+		switch key {
+		case KeyMessage:
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return Target{}, false, fmt.Errorf("target %d: invalid %s=%s", id, key, value)
+			}
+			target.Message = n
+		case KeyDelay:
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return Target{}, false, fmt.Errorf("target %d: invalid %s=%s", id, key, value)
+			}
+			target.Delay = n
+		case KeyBot:
+			if value == "" {
+				return Target{}, false, fmt.Errorf("target %d: empty %s", id, key)
+			}
+			target.Bot = value
+		default:
+			return Target{}, false, fmt.Errorf("target %d: unknown override %q", id, key)
+		}
+	}
+	return target, true, nil
+}
+
+func appendTag(tags []string, tag string) []string {
+	if tag == "" {
+		return tags
+	}
+	for _, t := range tags {
+		if t == tag {
+			return tags
+		}
+	}
+	return append(tags, tag)
+}
+
+// Merge 合并同一目标在多个列表中的标注,标签取并集,覆盖设置以other为准
+func (t Target) Merge(other Target) Target {
+	for _, tag := range other.Tags {
+		t.Tags = appendTag(t.Tags, tag)
+	}
+	if other.Message != 0 {
+		t.Message = other.Message
+	}
+	if other.Delay >= 0 {
+		t.Delay = other.Delay
+	}
+	if other.Bot != "" {
+		t.Bot = other.Bot
+	}
+	return t
+}
+
+// HasAnyTag 判断目标是否带有任意一个标签
+func (t Target) HasAnyTag(tags []string) bool {
+	for _, want := range tags {
+		for _, tag := range t.Tags {
+			if tag == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ParseTags 解析以逗号分隔的标签列表,允许带#
+func ParseTags(s string) []string {
+	var tags []string
+	for _, tag := range strings.Split(s, ",") {
+		tags = appendTag(tags, strings.TrimPrefix(strings.TrimSpace(tag), "#"))
+	}
+	return tags
+}
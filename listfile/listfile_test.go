@@ -0,0 +1,127 @@
+package listfile
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		line   string
+		want   Target
+		wantOK bool
+	}{
+		{"", Target{}, false},
+		{"   ", Target{}, false},
+		{"# 注释", Target{}, false},
+		{"#123456", Target{}, false},
+		{"123456", Target{ID: 123456, Delay: -1}, true},
+		{"\ufeff123456", Target{ID: 123456, Delay: -1}, true},
+		{"  123456\t", Target{ID: 123456, Delay: -1}, true},
+		{"123456 #vip #cn", Target{ID: 123456, Tags: []string{"vip", "cn"}, Delay: -1}, true},
+		// 重复和空的标签被忽略
+		{"123456 #vip #vip", Target{ID: 123456, Tags: []string{"vip"}, Delay: -1}, true},
+		{"123456 msg=2 delay=30", Target{ID: 123456, Message: 2, Delay: 30}, true},
+		{"123456 delay=0", Target{ID: 123456, Delay: 0}, true},
+		{"123456 bot=http://127.0.0.1:8081", Target{ID: 123456, Delay: -1, Bot: "http://127.0.0.1:8081"}, true},
+		// 单独的#之后为注释
+		{"123456 #vip msg=2 # 备注 msg=x", Target{ID: 123456, Tags: []string{"vip"}, Message: 2, Delay: -1}, true},
+		{"123456 # 备注", Target{ID: 123456, Delay: -1}, true},
+	}
+	for _, tt := range tests {
+		got, ok, err := ParseLine(tt.line)
+		if err != nil {
+			t.Errorf("ParseLine(%q): %v", tt.line, err)
+			continue
+		}
+		if ok != tt.wantOK || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseLine(%q) = %+v, %v, want %+v, %v", tt.line, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestParseLineInvalid(t *testing.T) {
+	tests := []string{
+		"abc",
+		"123456x",
+		"123456 vip",
+		"123456 msg=0",
+		"123456 msg=x",
+		"123456 delay=-1",
+		"123456 bot=",
+		"123456 foo=1",
+	}
+	for _, line := range tests {
+		if got, _, err := ParseLine(line); err == nil {
+			t.Errorf("ParseLine(%q) = %+v, want error", line, got)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		t, other Target
+		want     Target
+	}{
+		{
+			Target{ID: 1, Tags: []string{"vip"}, Delay: -1},
+			Target{ID: 1, Tags: []string{"cn", "vip"}, Delay: -1},
+			Target{ID: 1, Tags: []string{"vip", "cn"}, Delay: -1},
+		},
+		// 覆盖设置以other为准
+		{
+			Target{ID: 1, Message: 1, Delay: 10, Bot: "a"},
+			Target{ID: 1, Message: 2, Delay: 0, Bot: "b"},
+			Target{ID: 1, Message: 2, Delay: 0, Bot: "b"},
+		},
+		// other没有设置时保留原值
+		{
+			Target{ID: 1, Message: 1, Delay: 10, Bot: "a"},
+			Target{ID: 1, Delay: -1},
+			Target{ID: 1, Message: 1, Delay: 10, Bot: "a"},
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.t.Merge(tt.other); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%+v.Merge(%+v) = %+v, want %+v", tt.t, tt.other, got, tt.want)
+		}
+	}
+}
+
+func TestHasAnyTag(t *testing.T) {
+	target := Target{ID: 1, Tags: []string{"vip", "cn"}}
+	tests := []struct {
+		tags []string
+		want bool
+	}{
+		{[]string{"vip"}, true},
+		{[]string{"en", "cn"}, true},
+		{[]string{"en"}, false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := target.HasAnyTag(tt.tags); got != tt.want {
+			t.Errorf("HasAnyTag(%v) = %v, want %v", tt.tags, got, tt.want)
+		}
+	}
+	if (Target{ID: 1}).HasAnyTag([]string{"vip"}) {
+		t.Error("target without tags should not match")
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	tests := []struct {
+		s    string
+		want []string
+	}{
+		{"", nil},
+		{"vip", []string{"vip"}},
+		{"#vip, #cn", []string{"vip", "cn"}},
+		{"vip,,vip, cn ", []string{"vip", "cn"}},
+	}
+	for _, tt := range tests {
+		if got := ParseTags(tt.s); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseTags(%q) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/hoshinonyaruko/gensokyo-broadcast/listfile"
+	"github.com/hoshinonyaruko/gensokyo-broadcast/setexpr"
+	"github.com/hoshinonyaruko/gensokyo-broadcast/txt"
+)
+
+// 列表表达式中的操作数前缀
+const (
+	operandAPIGroups  = "api:groups"  // 从API实时获取的群列表
+	operandAPIFriends = "api:friends" // 从API实时获取的好友列表
+	operandSave       = "save:"       // 某次任务存档中发送成功的目标
+	operandTxt        = "txt:"        // txt列表文件,不写前缀时默认为列表文件
+)
+
+// tagFile 全局标注文件,为API获取的目标等没有列表文件的来源提供标签和覆盖设置
+const tagFile = "tags"
+
+// targetMeta 记录本次任务中目标的标签和覆盖设置,来自tags.txt和读取过的列表文件
+var targetMeta = make(map[int64]listfile.Target)
+
+// recordTargetMeta 合并目标的标注,后读取的列表覆盖先读取的
+func recordTargetMeta(target listfile.Target) {
+	if existing, ok := targetMeta[target.ID]; ok {
+		target = existing.Merge(target)
+	}
+	targetMeta[target.ID] = target
+}
+
+// targetOverrides 返回目标的标注,没有标注时覆盖设置均为空
+func targetOverrides(targetID int64) listfile.Target {
+	if target, ok := targetMeta[targetID]; ok {
+		return target
+	}
+	return listfile.Target{ID: targetID, Delay: -1}
+}
+
+// loadTagFile 读取tags.txt中的标注,文件不存在时忽略
+func loadTagFile(ts *txt.TxtStore) {
+	if !listFileExists(tagFile) {
+		return
+	}
+	if _, err := readGroupListFromTS(ts, tagFile, false); err != nil {
+		log.Printf("Failed to load %s.txt: %v", tagFile, err)
+	}
+}
+
+// filterByTags 只保留带有任意一个标签的目标
+func filterByTags(ids []int64, tags []string) []int64 {
+	var filtered []int64
+	for _, id := range ids {
+		if targetOverrides(id).HasAnyTag(tags) {
+			filtered = append(filtered, id)
+		}
+	}
+	return filtered
+}
+
+// sendDateRegex 匹配进度文件行尾的发送时间,列表中的标签和注释可能含有日期,所以只匹配行尾
+var sendDateRegex = regexp.MustCompile(`\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}$`)
+
+// excludedMark 进度文件中被排除的目标的标记,这样的行没有发送时间,不算已发送
+const excludedMark = "excluded(已排除):"
+
+// parseSaveLine 解析进度文件的一行,返回目标ID和是否已有发送记录
+func parseSaveLine(line string) (string, bool) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", false
+	}
+	sent := sendDateRegex.MatchString(strings.TrimSpace(line)) && !strings.Contains(line, excludedMark)
+	return fields[0], sent
+}
+
+// sentTargetsFromSave 返回存档中已有发送记录的目标
+func sentTargetsFromSave(ts *txt.TxtStore, saveName string) ([]int64, error) {
+	lines, err := ts.GetFileContent(saveName + "-save")
+	if err != nil {
+		return nil, err
+	}
+	var ids []int64
+	for _, line := range lines {
+		idStr, sent := parseSaveLine(line)
+		if !sent {
+			continue
+		}
+		if id, err := strconv.ParseInt(idStr, 10, 64); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// sendFailedPrefix 进度文件中发送失败的记录,见sendByMode
+const sendFailedPrefix = "失败: "
+
+// saveLineSucceeded 判断进度文件中的一行是否发送成功。
+// 调用失败的记录以"失败: "开头,接口返回status为failed或retcode不为0时记录的是原始响应
+func saveLineSucceeded(line string) bool {
+	if _, sent := parseSaveLine(line); !sent {
+		return false
+	}
+	result := strings.TrimSpace(sendDateRegex.ReplaceAllString(strings.TrimSpace(line), ""))
+	if strings.Contains(result, sendFailedPrefix) {
+		return false
+	}
+	if at := strings.Index(result, "{"); at >= 0 {
+		var apiErr *apiError
+		if errors.As(apiResponseError(result[at:]), &apiErr) {
+			return false
+		}
+	}
+	return true
+}
+
+// succeededTargetsFromSave 返回存档中发送成功的目标,用于save:操作数
+func succeededTargetsFromSave(ts *txt.TxtStore, saveName string) ([]int64, error) {
+	lines, err := ts.GetFileContent(saveName + "-save")
+	if err != nil {
+		return nil, err
+	}
+	var ids []int64
+	for _, line := range lines {
+		if !saveLineSucceeded(line) {
+			continue
+		}
+		idStr, _ := parseSaveLine(line)
+		if id, err := strconv.ParseInt(idStr, 10, 64); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// resolveListOperand 将列表表达式中的单个操作数解析为ID列表
+func resolveListOperand(ts *txt.TxtStore, apiURL string, token string, operand string) ([]int64, error) {
+	switch {
+	case operand == operandAPIGroups:
+		groupList, err := fetchGroupList(apiURL, token, false)
+		if err != nil {
+			return nil, err
+		}
+		recordGroupNames(groupList.Data)
+		ids := make([]int64, 0, len(groupList.Data))
+		for _, group := range groupList.Data {
+			ids = append(ids, group.GroupID)
+		}
+		return ids, nil
+	case operand == operandAPIFriends:
+		friendList, err := fetchFriendList(apiURL, token, false)
+		if err != nil {
+			return nil, err
+		}
+		recordFriendNames(friendList.Data)
+		ids := make([]int64, 0, len(friendList.Data))
+		for _, friend := range friendList.Data {
+			if id, err := strconv.ParseInt(friend.UserID, 10, 64); err == nil {
+				ids = append(ids, id)
+			}
+		}
+		return ids, nil
+	case isSnapshotOperand(operand):
+		return resolveSnapshotOperand(ts, apiURL, token, operand)
+	case strings.HasPrefix(operand, operandSave):
+		return succeededTargetsFromSave(ts, strings.TrimPrefix(operand, operandSave))
+	default:
+		name := strings.TrimSuffix(strings.TrimPrefix(operand, operandTxt), ".txt")
+		return readGroupListFromTS(ts, name, false)
+	}
+}
+
+// evalListExpr 计算列表表达式
+func evalListExpr(ts *txt.TxtStore, apiURL string, token string, expr string) ([]int64, error) {
+	return setexpr.Eval(expr, func(operand string) ([]int64, error) {
+		return resolveListOperand(ts, apiURL, token, operand)
+	})
+}
+
+// saveIDList 将ID列表保存为txt列表文件,每行一个
+func saveIDList(ids []int64, filename string) error {
+	lines := make([]string, 0, len(ids))
+	for _, id := range ids {
+		lines = append(lines, strconv.FormatInt(id, 10))
+	}
+	if err := writeLines(lines, filename); err != nil {
+		return fmt.Errorf("failed to save list to %s: %w", filename, err)
+	}
+	return nil
+}
+
+// listFileExists 检查列表文件是否已存在,避免覆盖
+func listFileExists(name string) bool {
+	_, err := os.Stat(name + ".txt")
+	return err == nil
+}
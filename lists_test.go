@@ -0,0 +1,49 @@
+package main
+
+import "testing"
+
+func TestParseSaveLine(t *testing.T) {
+	tests := []struct {
+		line     string
+		wantID   string
+		wantSent bool
+	}{
+		{"", "", false},
+		{"123456", "123456", false},
+		{"123456 #vip bot=http://127.0.0.1:8080", "123456", false},
+		{`123456 {"status": "ok", "retcode": 0} 2024-06-01 20:00:00`, "123456", true},
+		{"123456 失败: timeout 2024-06-01 20:00:00", "123456", true},
+		{"123456 #活动2024-06-01 12:00:00 备注", "123456", false},
+		// 被排除的目标没有发送时间,理由中含有日期也不算已发送
+		{"123456 excluded(已排除): 规则3 本人要求", "123456", false},
+		{"123456 excluded(已排除): 规则3 截止2024-06-01 20:00:00", "123456", false},
+	}
+	for _, tt := range tests {
+		id, sent := parseSaveLine(tt.line)
+		if id != tt.wantID || sent != tt.wantSent {
+			t.Errorf("parseSaveLine(%q) = %q, %v, want %q, %v", tt.line, id, sent, tt.wantID, tt.wantSent)
+		}
+	}
+}
+
+func TestSaveLineSucceeded(t *testing.T) {
+	tests := []struct {
+		line string
+		want bool
+	}{
+		{`1 {"status": "ok", "retcode": 0, "data": null} 2024-06-01 20:00:00`, true},
+		{`1 #vip {"status":"ok","retcode":0} 2024-06-01 20:00:00`, true},
+		{`1 降级为纯文本: {"status":"ok","retcode":0} 2024-06-01 20:00:00`, true},
+		{"1 失败: failed to send POST request 2024-06-01 20:00:00", false},
+		{`1 {"status":"failed","retcode":100,"msg":"群不存在"} 2024-06-01 20:00:00`, false},
+		{`1 {"status":"ok","retcode":1404} 2024-06-01 20:00:00`, false},
+		{"1 excluded(已排除): 规则1 合作群", false},
+		{"1", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := saveLineSucceeded(tt.line); got != tt.want {
+			t.Errorf("saveLineSucceeded(%q) = %v, want %v", tt.line, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package lockout
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hoshinonyaruko/gensokyo-broadcast/filelock"
+)
+
+// 登入失败记录文件,CLI和WebUI共用,CLI解锁后立即生效
+const lockoutFile = "lockouts.json"
+
+// 锁文件,CLI解锁和WebUI登入在不同进程中修改记录文件
+const lockFile = lockoutFile + ".lock"
+
+// 记录的类型前缀
+const (
+	KindAccount = "account"
+	KindIP      = "ip"
+)
+
+// 锁定时间每次翻倍,最长一天
+const maxLockout = 24 * time.Hour
+
+// 超过这么久没有失败且未锁定的记录会被清理,连续锁定的次数也重新计算
+const forgetAfter = 24 * time.Hour
+
+// Record 一个用户名或IP的登入失败记录
+type Record struct {
+	Key         string    `json:"key"` // account:用户名 或 ip:地址
+	Failures    int       `json:"failures"`
+	Lockouts    int       `json:"lockouts"` // 连续锁定的次数,决定下一次锁定的时间
+	LastFailure time.Time `json:"last_failure"`
+	LockedUntil time.Time `json:"locked_until,omitempty"`
+}
+
+// Locked 判断记录在now时是否锁定
+func (r Record) Locked(now time.Time) bool {
+	return now.Before(r.LockedUntil)
+}
+
+// Policy 锁定策略,失败MaxAttempts次后锁定,每次锁定的时间是上一次的两倍
+type Policy struct {
+	MaxAttempts int
+	BaseLockout time.Duration
+}
+
+// Key 返回用户名或IP对应的记录键
+func Key(kind, value string) string {
+	return kind + ":" + value
+}
+
+var mu sync.Mutex
+
+func load() (map[string]Record, error) {
+	records := make(map[string]Record)
+	data, err := os.ReadFile(lockoutFile)
+	if os.IsNotExist(err) {
+		return records, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []Record
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", lockoutFile, err)
+	}
+	for _, r := range list {
+		records[r.Key] = r
+	}
+	return records, nil
+}
+
+// save 保存记录,清理已经失效的记录
+func save(records map[string]Record, now time.Time) error {
+	list := make([]Record, 0, len(records))
+	for _, r := range records {
+		if !r.Locked(now) && now.Sub(r.LastFailure) > forgetAfter {
+			continue
+		}
+		list = append(list, r)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Key < list[j].Key })
+	data, err := json.MarshalIndent(list, "", "    ")
+	if err != nil {
+		return err
+	}
+	// 先写临时文件再替换,不加锁的Check和List不会读到不完整的文件
+	tmp := lockoutFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, lockoutFile)
+}
+
+// Check 返回keys中第一个处于锁定状态的记录
+func Check(now time.Time, keys ...string) (Record, bool, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	records, err := load()
+	if err != nil {
+		return Record{}, false, err
+	}
+	for _, key := range keys {
+		if r, ok := records[key]; ok && r.Locked(now) {
+			return r, true, nil
+		}
+	}
+	return Record{}, false, nil
+}
+
+// update 在锁内重新读取记录并修改,fn返回true时保存
+func update(now time.Time, fn func(records map[string]Record) bool) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	unlock, err := filelock.Lock(lockFile)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	records, err := load()
+	if err != nil {
+		return err
+	}
+	if !fn(records) {
+		return nil
+	}
+	return save(records, now)
+}
+
+// Fail 记录一次失败,policies与keys一一对应,返回因这次失败而新锁定的记录
+func Fail(now time.Time, policies []Policy, keys ...string) ([]Record, error) {
+	var locked []Record
+	err := update(now, func(records map[string]Record) bool {
+		for i, key := range keys {
+			r := records[key]
+			r.Key = key
+			if now.Sub(r.LastFailure) > forgetAfter {
+				r.Failures, r.Lockouts = 0, 0
+			}
+			r.Failures++
+			r.LastFailure = now
+			if policy := policies[i]; policy.MaxAttempts > 0 && r.Failures >= policy.MaxAttempts {
+				// 渐进式锁定: 1倍、2倍、4倍...
+				duration := policy.BaseLockout << r.Lockouts
+				if duration > maxLockout || duration <= 0 {
+					duration = maxLockout
+				}
+				r.LockedUntil = now.Add(duration)
+				r.Lockouts++
+				r.Failures = 0
+				locked = append(locked, r)
+			}
+			records[key] = r
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return locked, nil
+}
+
+// Succeed 登入成功后清除该记录的失败次数和锁定次数
+func Succeed(now time.Time, key string) error {
+	return update(now, func(records map[string]Record) bool {
+		if _, ok := records[key]; !ok {
+			return false
+		}
+		delete(records, key)
+		return true
+	})
+}
+
+// Unlock 解除锁定并清除失败记录,key为空时解除全部,返回解除的数量
+func Unlock(key string) (int, error) {
+	count := 0
+	err := update(time.Now(), func(records map[string]Record) bool {
+		for k := range records {
+			if key == "" || k == key {
+				delete(records, k)
+				count++
+			}
+		}
+		return true
+	})
+	return count, err
+}
+
+// List 返回全部记录,锁定中的在前
+func List() ([]Record, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	records, err := load()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	list := make([]Record, 0, len(records))
+	for _, r := range records {
+		list = append(list, r)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Locked(now) != list[j].Locked(now) {
+			return list[i].Locked(now)
+		}
+		return strings.Compare(list[i].Key, list[j].Key) < 0
+	})
+	return list, nil
+}
@@ -0,0 +1,181 @@
+package lockout
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+// inTempDir 切换到临时目录,记录文件写在当前目录下
+func inTempDir(t *testing.T) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+var base = time.Date(2026, 10, 16, 10, 0, 0, 0, time.UTC)
+
+func TestBackoff(t *testing.T) {
+	inTempDir(t)
+	policy := []Policy{{MaxAttempts: 3, BaseLockout: time.Minute}}
+	key := Key(KindAccount, "admin")
+
+	// 每次锁定的时间是上一次的两倍,最长一天
+	want := []time.Duration{
+		time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute,
+		16 * time.Minute, 32 * time.Minute, 64 * time.Minute, 128 * time.Minute,
+		256 * time.Minute, 512 * time.Minute, 1024 * time.Minute, maxLockout, maxLockout,
+	}
+	now := base
+	for i, duration := range want {
+		for attempt := 1; attempt <= 3; attempt++ {
+			locked, err := Fail(now, policy, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if attempt < 3 && len(locked) != 0 {
+				t.Fatalf("lockout %d: locked after %d failures", i+1, attempt)
+			}
+			if attempt == 3 {
+				if len(locked) != 1 {
+					t.Fatalf("lockout %d: not locked after %d failures", i+1, attempt)
+				}
+				if got := locked[0].LockedUntil.Sub(now); got != duration {
+					t.Errorf("lockout %d: locked for %s, want %s", i+1, got, duration)
+				}
+			}
+		}
+		if _, ok, _ := Check(now.Add(duration-time.Second), key); !ok {
+			t.Errorf("lockout %d: not locked before it expires", i+1)
+		}
+		now = now.Add(duration)
+		if _, ok, _ := Check(now, key); ok {
+			t.Errorf("lockout %d: still locked after it expires", i+1)
+		}
+	}
+}
+
+func TestForget(t *testing.T) {
+	inTempDir(t)
+	policy := []Policy{{MaxAttempts: 2, BaseLockout: time.Minute}}
+	key := Key(KindIP, "10.0.0.1")
+
+	now := base
+	for i := 0; i < 4; i++ {
+		if _, err := Fail(now, policy, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 超过一天没有失败后失败次数和锁定次数重新计算
+	now = now.Add(forgetAfter + time.Minute)
+	if locked, _ := Fail(now, policy, key); len(locked) != 0 {
+		t.Fatal("failure count was not reset")
+	}
+	locked, err := Fail(now, policy, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locked) != 1 || locked[0].LockedUntil.Sub(now) != time.Minute {
+		t.Errorf("locked = %+v, want one lockout of 1m", locked)
+	}
+}
+
+func TestPerKey(t *testing.T) {
+	inTempDir(t)
+	policies := []Policy{
+		{MaxAttempts: 2, BaseLockout: time.Minute},
+		{MaxAttempts: 3, BaseLockout: time.Hour},
+	}
+	account, ip := Key(KindAccount, "admin"), Key(KindIP, "10.0.0.1")
+
+	Fail(base, policies, account, ip)
+	locked, err := Fail(base, policies, account, ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locked) != 1 || locked[0].Key != account {
+		t.Fatalf("locked = %+v, want only %s", locked, account)
+	}
+	if r, ok, _ := Check(base, ip, account); !ok || r.Key != account {
+		t.Errorf("Check = %+v, %v, want %s locked", r, ok, account)
+	}
+	if _, ok, _ := Check(base, ip, Key(KindAccount, "other")); ok {
+		t.Error("other keys should not be locked")
+	}
+
+	locked, _ = Fail(base, policies, Key(KindAccount, "other"), ip)
+	if len(locked) != 1 || locked[0].Key != ip || locked[0].LockedUntil.Sub(base) != time.Hour {
+		t.Errorf("locked = %+v, want %s locked for 1h", locked, ip)
+	}
+
+	// 登入成功只清除对应的记录
+	if err := Succeed(base, account); err != nil {
+		t.Fatal(err)
+	}
+	if r, ok, _ := Check(base, account, ip); !ok || r.Key != ip {
+		t.Errorf("Check = %+v, %v, want %s still locked", r, ok, ip)
+	}
+}
+
+func TestUnlock(t *testing.T) {
+	inTempDir(t)
+	policies := []Policy{{MaxAttempts: 1, BaseLockout: time.Hour}, {MaxAttempts: 1, BaseLockout: time.Hour}}
+	now := time.Now()
+	a, b := Key(KindAccount, "a"), Key(KindAccount, "b")
+	if _, err := Fail(now, policies, a, b); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := Unlock(a); err != nil || n != 1 {
+		t.Fatalf("Unlock(a) = %d, %v, want 1", n, err)
+	}
+	if _, ok, _ := Check(now, a); ok {
+		t.Error("a is still locked")
+	}
+	list, err := List()
+	if err != nil || len(list) != 1 || list[0].Key != b {
+		t.Fatalf("List = %+v, %v, want only %s", list, err, b)
+	}
+	if n, err := Unlock(""); err != nil || n != 1 {
+		t.Errorf("Unlock(\"\") = %d, %v, want 1", n, err)
+	}
+}
+
+func TestLockFile(t *testing.T) {
+	inTempDir(t)
+	policy := []Policy{{MaxAttempts: 1, BaseLockout: time.Minute}}
+	key := Key(KindAccount, "admin")
+
+	// CLI解锁时持有锁,WebUI记录失败需要等待,并读取解锁后的记录
+	if err := os.WriteFile(lockFile, []byte("1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	released := make(chan struct{})
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		os.WriteFile(lockoutFile, []byte(`[{"key":"account:other","failures":1,"lockouts":0,"last_failure":"`+base.Format(time.RFC3339)+`"}]`), 0600)
+		close(released)
+		os.Remove(lockFile)
+	}()
+	if _, err := Fail(base, policy, key); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-released:
+	default:
+		t.Fatal("Fail did not wait for the lock")
+	}
+	list, err := List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 {
+		t.Errorf("List = %+v, want the record written while locked and %s", list, key)
+	}
+}
@@ -13,21 +13,36 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
-	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
+	"text/template"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/hoshinonyaruko/gensokyo-broadcast/config"
+	"github.com/hoshinonyaruko/gensokyo-broadcast/exclude"
+	"github.com/hoshinonyaruko/gensokyo-broadcast/filter"
+	"github.com/hoshinonyaruko/gensokyo-broadcast/httpclient"
+	"github.com/hoshinonyaruko/gensokyo-broadcast/listfile"
+	"github.com/hoshinonyaruko/gensokyo-broadcast/media"
+	"github.com/hoshinonyaruko/gensokyo-broadcast/optout"
+	"github.com/hoshinonyaruko/gensokyo-broadcast/ratelimit"
 	"github.com/hoshinonyaruko/gensokyo-broadcast/sys"
 	"github.com/hoshinonyaruko/gensokyo-broadcast/txt"
 	"github.com/hoshinonyaruko/gensokyo-broadcast/webui"
+	"github.com/hoshinonyaruko/gensokyo-broadcast/window"
 	"golang.org/x/text/encoding/simplifiedchinese"
 	"golang.org/x/text/transform"
 )
 
+// dryRun 预演模式,不写入列表文件、.bat模板和快照
+var dryRun bool
+
+// templateMessages 用-template开启后才按模板渲染消息,否则消息中的{{原样发送
+var templateMessages bool
+
 type CommandLineArgs struct {
 	ApiAddress     string
 	GroupListFile  string
@@ -40,8 +55,30 @@ type CommandLineArgs struct {
 	FriendMode     bool
 	Token          string
 	RandomList     bool
+	Template       bool
+	Mode           string
+	DryRun         bool
+	ReportFile     string
+	Filter         string
+	ListExpr       string
+	Tags           string
+	Mention        string
+	Window         string
+	Workers        int
+	Rate           int
+	BotRate        int
+	TargetRate     int
 }
 
+// 广播模式
+const (
+	ModeMessage  = "msg"      // 普通消息,默认
+	ModeNotice   = "notice"   // 群公告 _send_group_notice
+	ModeFile     = "file"     // 群文件 upload_group_file
+	ModeForward  = "forward"  // 合并转发 send_group_forward_msg/send_private_forward_msg
+	ModeMarkdown = "markdown" // QQ开放平台markdown和按钮,不支持时降级为纯文本
+)
+
 type GroupList struct {
 	Data    []Group     `json:"data"`
 	Message string      `json:"message"`
@@ -134,6 +171,39 @@ func saveArgsToBatFile(args CommandLineArgs) {
 	if args.RandomList {
 		cmdLine.WriteString(" -r")
 	}
+	if args.Template {
+		cmdLine.WriteString(" -template")
+	}
+	if args.ListExpr != "" {
+		cmdLine.WriteString(fmt.Sprintf(" -l \"%s\"", args.ListExpr))
+	}
+	if args.Filter != "" {
+		cmdLine.WriteString(fmt.Sprintf(" -filter \"%s\"", args.Filter))
+	}
+	if args.Tags != "" {
+		cmdLine.WriteString(fmt.Sprintf(" -tags %s", args.Tags))
+	}
+	if args.Mention != "" {
+		cmdLine.WriteString(fmt.Sprintf(" -at %s", args.Mention))
+	}
+	if args.Window != "" {
+		cmdLine.WriteString(fmt.Sprintf(" -window \"%s\"", args.Window))
+	}
+	if args.Workers > 1 {
+		cmdLine.WriteString(fmt.Sprintf(" -workers %d", args.Workers))
+	}
+	if args.Rate > 0 {
+		cmdLine.WriteString(fmt.Sprintf(" -rate %d", args.Rate))
+	}
+	if args.BotRate > 0 {
+		cmdLine.WriteString(fmt.Sprintf(" -bot-rate %d", args.BotRate))
+	}
+	if args.TargetRate > 0 {
+		cmdLine.WriteString(fmt.Sprintf(" -target-rate %d", args.TargetRate))
+	}
+	if args.Mode != "" && args.Mode != ModeMessage {
+		cmdLine.WriteString(fmt.Sprintf(" -m %s", args.Mode))
+	}
 	cmdLine.WriteString("\n")
 
 	// 将命令行参数以GBK编码写入到.bat文件中
@@ -167,10 +237,25 @@ func parseArgs() CommandLineArgs {
 	flag.BoolVar(&args.FriendMode, "f", false, "私聊模式")
 	flag.StringVar(&args.Token, "t", "", "access_token")
 	flag.BoolVar(&args.RandomList, "r", false, "打乱群/好友列表顺序")
+	flag.BoolVar(&args.Template, "template", false, "按模板渲染消息,可引用本地图片和语音")
+	flag.BoolVar(&args.DryRun, "n", false, "预演模式,只生成报告不发送")
+	flag.StringVar(&args.ReportFile, "report", "", "预演报告的文件名")
+	flag.StringVar(&args.ListExpr, "l", "", "列表表达式,组合多个列表")
+	flag.StringVar(&args.Filter, "filter", "", "按群或好友资料过滤目标")
+	flag.StringVar(&args.Tags, "tags", "", "只发送给带有这些标签的目标,逗号分隔")
+	flag.StringVar(&args.Mention, "at", MentionNone, "在消息前@全体成员(all)或群主和管理员(admins)")
+	flag.StringVar(&args.Window, "window", "", "允许发送的时段,如 09:00-22:00")
+	flag.IntVar(&args.Workers, "workers", 1, "同时发送的数量")
+	flag.IntVar(&args.Rate, "rate", 0, "全局每分钟最多发送的数量,0为不限制")
+	flag.IntVar(&args.BotRate, "bot-rate", 0, "每个机器人每分钟最多发送的数量,0为不限制")
+	flag.IntVar(&args.TargetRate, "target-rate", 0, "每个目标每分钟最多发送的数量,0为不限制")
+	flag.StringVar(&args.Mode, "m", ModeMessage, "广播模式 msg/notice/file/forward/markdown")
 	flag.Parse()
 
-	// 保存命令行参数到.bat文件
-	saveArgsToBatFile(args)
+	// 保存命令行参数到.bat文件,预演模式下不写入
+	if !args.DryRun {
+		saveArgsToBatFile(args)
+	}
 
 	return args
 }
@@ -180,9 +265,17 @@ func main() {
 	if len(os.Args) == 1 {
 		// 读取或创建配置
 		jsonconfig := config.ReadConfig()
+		setupHTTPClient(jsonconfig)
 
 		//cookie数据库
 		webui.InitializeDB()
+		webui.StartSessionSweeper()
+
+		//登入用户
+		webui.InitAccount(jsonconfig)
+
+		//定时任务
+		webui.StartScheduler(jsonconfig)
 
 		//给程序整个标题
 		sys.SetTitle(jsonconfig.Title + " 作者 早苗狐 答疑群:196173384")
@@ -200,17 +293,51 @@ func main() {
 		// 正常退出程序
 		os.Exit(0)
 	} else {
-		// 有命令行参数，执行原有逻辑
-		runCommandLineLogic()
+		// 命令行模式同样使用config.json中的HTTP设置,但不创建配置文件
+		jsonconfig, err := config.LoadConfig()
+		if err != nil {
+			fmt.Printf("配置读取失败, 使用默认的HTTP设置: %v\n", err)
+		}
+		setupHTTPClient(jsonconfig)
+
+		if !runSubcommand(os.Args[1:]) {
+			// 有命令行参数，执行原有逻辑
+			runCommandLineLogic()
+		}
+	}
+}
+
+// setupHTTPClient 按配置设置调用onebot api使用的HTTP客户端
+func setupHTTPClient(jsonconfig config.Config) {
+	err := httpclient.Configure(httpclient.Options{
+		Timeout:            time.Duration(jsonconfig.HTTPTimeout) * time.Second,
+		CAFile:             jsonconfig.HTTPCA,
+		CertFile:           jsonconfig.HTTPClientCert,
+		KeyFile:            jsonconfig.HTTPClientKey,
+		InsecureSkipVerify: jsonconfig.HTTPInsecureSkipVerify,
+		Proxy:              jsonconfig.HTTPProxy,
+		TokenInQuery:       jsonconfig.TokenInQuery,
+	})
+	if err != nil {
+		log.Fatalf("无法设置HTTP客户端: %v", err)
+	}
+	if jsonconfig.HTTPInsecureSkipVerify {
+		fmt.Println("警告: 已关闭onebot api的证书验证,仅用于测试环境")
 	}
 }
 
 func startWebServer(jsonconfig config.Config) {
 
 	r := gin.Default()
+	// 默认不信任任何代理,否则客户端可以伪造X-Forwarded-For绕过按IP的登入锁定
+	if err := r.SetTrustedProxies(jsonconfig.TrustedProxies); err != nil {
+		log.Fatalf("trustedProxies配置错误: %v", err)
+	}
 
 	//webui和它的api
 	webuiGroup := r.Group("/webui")
+	// api接口统一认证和防CSRF
+	webuiGroup.Use(webui.AuthMiddleware())
 	{
 		webuiGroup.GET("/*filepath", webui.CombinedMiddleware(jsonconfig))
 		webuiGroup.POST("/*filepath", webui.CombinedMiddleware(jsonconfig))
@@ -219,6 +346,21 @@ func startWebServer(jsonconfig config.Config) {
 		webuiGroup.PATCH("/*filepath", webui.CombinedMiddleware(jsonconfig))
 	}
 
+	// onebot事件上报,用于处理退订。服务监听在所有地址上,没有密钥时不开启对应的接收方式
+	if jsonconfig.OptOutEnabled {
+		if jsonconfig.EventSecret != "" {
+			r.POST("/onebot/event", optout.HTTPHandler(jsonconfig))
+			fmt.Printf("退订事件接收已开启,http上报地址/onebot/event\n")
+		}
+		if jsonconfig.EventToken != "" {
+			r.GET("/onebot/ws", optout.WebSocketHandler(jsonconfig))
+			fmt.Printf("退订事件接收已开启,反向websocket地址/onebot/ws\n")
+		}
+		if jsonconfig.EventSecret == "" && jsonconfig.EventToken == "" {
+			fmt.Println("警告: 已开启optOutEnabled但没有设置eventSecret或eventToken,不接收退订事件")
+		}
+	}
+
 	// 创建一个http.Server实例(主服务器)
 	httpServer := &http.Server{
 		Addr:    "0.0.0.0:" + jsonconfig.Port,
@@ -226,18 +368,16 @@ func startWebServer(jsonconfig config.Config) {
 	}
 
 	if jsonconfig.UseHttps {
+		tlsConfig, err := httpsConfig(jsonconfig)
+		if err != nil {
+			log.Fatalf("无法加载https证书: %v", err)
+		}
+		httpServer.TLSConfig = tlsConfig
 		fmt.Printf("webui-api运行在 HTTPS 端口 %v\n", jsonconfig.Port)
 		// 在一个新的goroutine中启动主服务器
 		go func() {
-			// 定义默认的证书和密钥文件名 自签名证书
-			certFile := "cert.pem"
-			keyFile := "key.pem"
-			if jsonconfig.Cert != "" && jsonconfig.Key != "" {
-				certFile = jsonconfig.Cert
-				keyFile = jsonconfig.Key
-			}
-			// 使用 HTTPS
-			if err := httpServer.ListenAndServeTLS(certFile, keyFile); err != nil && err != http.ErrServerClosed {
+			// 使用 HTTPS,证书由TLSConfig提供
+			if err := httpServer.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
 				log.Fatalf("listen: %s\n", err)
 			}
 
@@ -262,6 +402,8 @@ func runCommandLineLogic() {
 		showHelp()
 		return
 	}
+	dryRun = args.DryRun
+	templateMessages = args.Template
 	executeTaskBasedOnArgs(ts, args)
 }
 
@@ -270,57 +412,223 @@ func showHelp() {
 	fmt.Println("-a  HTTP API 的地址。示例: -a http://localhost:8080")
 	fmt.Println("-p  指定群列表的txt文件名(不包括.txt后缀)。示例: -p group_list")
 	fmt.Println("-w  要发送的信息内容。如果包含.txt则尝试从对应的txt文件中读取内容。示例: -w message.txt 或 -w '这是一条消息'||'这是另一条消息'")
+	fmt.Println("    加上-template时信息内容按模板渲染,可引用程序目录或当前目录下的文件,发送时编码为base64。示例: -template -w '新版本上线{{image \"poster.png\"}}',语音使用{{record \"voice.mp3\"}}")
 	fmt.Println("-s  必须,存档名,指定-save文件路径,用于断点续发。示例: -s 本次任务代号,指定新文件代表从头开始任务。不需要加-save和后缀。")
 	fmt.Println("-d  *每条信息推送时间间隔（秒）。示例: -d 15, 默认为10秒。")
 	fmt.Println("-c  *每个群推送的概率（百分比）。示例: -c 50, 默认为100%，即总是推送。")
 	fmt.Println("-h  *显示帮助信息。不需要值，仅标志存在即可。")
 	fmt.Println("-g  *QQ开放平台频道智能选择,ture=每个频道首个文字子频道广播,false=全部子频道都发送广播,不需要值，仅标志存在即可。")
 	fmt.Println("-f  *私聊模式,仅限发送通知,不要发送骚扰信息。请遵守调用限制.")
-	fmt.Println("-t  *access_token,如果你设置了http的密钥则需要这个参数.")
+	fmt.Println("-t  *access_token,如果你设置了http的密钥则需要这个参数.通过Authorization请求头发送,超时、证书和代理在config.json中设置.")
 	fmt.Println("-r  *打乱群和好友列表的顺序.")
+	fmt.Println("-template  *按模板渲染信息内容,包括合并转发的节点和markdown的fallback。不加时消息中的{{原样发送。不需要值，仅标志存在即可。")
+	fmt.Println("发送前会检查排除列表,被排除的目标在进度中记录为excluded。管理排除列表请使用 exclude 子命令,如: exclude list")
+	fmt.Println("-n  *预演模式,解析目标、概率和消息模板并生成-preview.json报告,不发送任何消息,也不写入-save存档、列表文件、.bat模板和快照。不需要值，仅标志存在即可。")
+	fmt.Println("-report  *预演报告的文件名,默认为 存档名-preview.json。示例: -n -report 活动预演.json")
+	fmt.Println("-l  *列表表达式,用 + 并集、& 交集、- 差集组合多个列表,从左到右计算,可用括号。")
+	fmt.Println("    运算符两侧必须有空格,因为列表名中可以有-,如 a-b 是名为a-b的列表, a - b 才是差集。")
+	fmt.Println("    操作数: 列表文件名(或txt:列表名)、api:groups、api:friends、save:存档名(该任务发送成功的目标)。")
+	fmt.Println("    示例: -l \"api:groups - save:上周活动 & vip群\"。预览或保存结果请使用 list 子命令。")
+	fmt.Println("-filter  *按群或好友资料过滤目标,多个条件用&&连接,支持>= <= > < = != 以及正则~ !~。")
+	fmt.Println("         群字段: group_id group_name group_memo member_count max_member_count group_level group_create_time(可与日期比较,如2024-01-01)")
+	fmt.Println("         好友字段: user_id nickname remark。示例: -filter \"member_count>=50 && group_name~^官方\"")
+	fmt.Println("-tags  *只发送给带有任意一个标签的目标,逗号分隔。标签写在列表文件中,如 123456 #vip #cn,API获取的目标可在tags.txt中标注。示例: -tags vip,cn")
+	fmt.Println("-at  *在群消息前@,all=机器人是群主或管理员且还有次数时@全体成员,否则@群主和管理员;admins=总是@群主和管理员。示例: -at all")
+	fmt.Println("-window  *允许发送的时段(本地时间),时段外自动暂停,到时段内继续。示例: -window 09:00-22:00")
+	fmt.Println("         按星期设置用;分隔,没有出现的星期不发送: -window \"mon-fri 09:00-22:00; sat,sun 10:00-12:00,14:00-21:00\"")
+	fmt.Println("-workers  *同时发送的数量,默认为1即逐个发送。每个发送者发送后各自等待-d秒。示例: -workers 4")
+	fmt.Println("-rate  *全局每分钟最多发送的数量,0为不限制。示例: -rate 30")
+	fmt.Println("-bot-rate  *每个机器人(-a或列表中的bot=)每分钟最多发送的数量,0为不限制。示例: -bot-rate 20")
+	fmt.Println("-target-rate  *每个群或好友每分钟最多发送的数量,包括markdown降级后补发的纯文本,0为不限制。示例: -target-rate 1")
+	fmt.Println("-m  *广播模式,msg=普通消息(默认),notice=群公告,file=群文件(-w填写本地文件路径)。forward=合并转发(-w填写节点定义的json文件,配合-f发送私聊合并转发),markdown=QQ开放平台markdown和按钮(-w填写json文件,不支持时发送fallback纯文本)。notice和file仅支持群,不能与-f同时使用。示例: -m notice")
 }
 
 func executeTaskBasedOnArgs(ts *txt.TxtStore, args CommandLineArgs) {
+	// 检查广播模式
+	switch args.Mode {
+	case ModeMessage, ModeForward, ModeMarkdown:
+	case ModeNotice, ModeFile:
+		if args.FriendMode {
+			log.Fatalf("Mode %s only supports groups, cannot be used with -f", args.Mode)
+		}
+	default:
+		log.Fatalf("Unknown mode: %s", args.Mode)
+	}
+
+	// 检查@设置,只有群消息可以@
+	switch args.Mention {
+	case MentionNone:
+	case MentionAll, MentionAdmins:
+		if args.FriendMode || args.Mode != ModeMessage {
+			log.Fatalf("-at only supports group messages (-m msg without -f)")
+		}
+	default:
+		log.Fatalf("Unknown -at value: %s, use all or admins", args.Mention)
+	}
+
+	// 解析过滤条件
+	var targetFilter *filter.Filter
+	var err error
+	if args.Filter != "" {
+		targetFilter, err = filter.Parse(args.Filter)
+		if err == nil {
+			if args.FriendMode {
+				err = targetFilter.Check(FriendData{})
+			} else {
+				err = targetFilter.Check(Group{})
+			}
+		}
+		if err != nil {
+			log.Fatalf("Invalid filter: %v", err)
+		}
+	}
+
+	// 解析发送时段
+	var sendWindow *window.Window
+	if args.Window != "" {
+		sendWindow, err = window.Parse(args.Window)
+		if err != nil {
+			log.Fatalf("Invalid send window: %v", err)
+		}
+	}
+
+	// 读取全局标注,列表文件中的标注会覆盖它
+	loadTagFile(ts)
+	var tags []string
+	if args.Tags != "" {
+		tags = listfile.ParseTags(args.Tags)
+	}
+
 	// 根据参数执行逻辑
 	var groupIDs []int64
-	var err error
 	var filename string
 	// 根据提供的参数执行不同的逻辑
-	if args.GroupListFile == "" {
+	if args.ListExpr != "" {
+		// 计算列表表达式
+		groupIDs, err = evalListExpr(ts, args.ApiAddress, args.Token, args.ListExpr)
+		if err != nil {
+			log.Fatalf("Failed to evaluate list expression: %v", err)
+		}
+		fmt.Printf("列表表达式'%s'的结果为%d个群或好友\n", args.ListExpr, len(groupIDs))
+		if targetFilter != nil {
+			groupIDs, err = filterListByMetadata(groupIDs, args.ApiAddress, args.Token, args.FriendMode, targetFilter)
+			if err != nil {
+				log.Fatalf("Failed to filter group list: %v", err)
+			}
+			fmt.Printf("按过滤条件'%s'筛选后剩余%d个群或好友\n", targetFilter, len(groupIDs))
+		}
+		if tags != nil {
+			groupIDs = filterByTags(groupIDs, tags)
+			fmt.Printf("按标签'%s'筛选后剩余%d个群或好友\n", args.Tags, len(groupIDs))
+		}
+		if args.RandomList {
+			rand.Shuffle(len(groupIDs), func(i, j int) {
+				groupIDs[i], groupIDs[j] = groupIDs[j], groupIDs[i]
+			})
+		}
+		// 与从API获取时一样保存本次的目标列表,作为进度文件的原始列表
+		filename = fmt.Sprintf("%d-%s.txt", time.Now().Unix(), args.SaveFilePath)
+		if !args.DryRun {
+			if err := saveIDList(groupIDs, filename); err != nil {
+				log.Fatalf("%v", err)
+			}
+		}
+	} else if args.GroupListFile == "" {
 		// 从API获取群列表并保存
 		if !args.FriendMode {
-			groupIDs, filename, err = fetchAndSaveGroupList(args.ApiAddress, args.SaveFilePath, args.FilterChannel, args.Token, args.RandomList)
+			groupIDs, filename, err = fetchAndSaveGroupList(args.ApiAddress, args.SaveFilePath, args.FilterChannel, args.Token, args.RandomList, targetFilter)
 			if err != nil {
 				log.Fatalf("Failed to read group list from file: %v", err)
 			}
 		} else {
-			groupIDs, filename, err = fetchAndSaveFriendList(args.ApiAddress, args.SaveFilePath, args.Token, args.RandomList)
+			groupIDs, filename, err = fetchAndSaveFriendList(args.ApiAddress, args.SaveFilePath, args.Token, args.RandomList, targetFilter)
 			if err != nil {
 				log.Fatalf("Failed to read group list from file: %v", err)
 			}
 		}
+		// API获取的目标没有标注,标签来自tags.txt,筛选后重新保存列表
+		if tags != nil {
+			groupIDs = filterByTags(groupIDs, tags)
+			fmt.Printf("按标签'%s'筛选后剩余%d个群或好友\n", args.Tags, len(groupIDs))
+			if !args.DryRun {
+				if err := saveIDList(groupIDs, filename); err != nil {
+					log.Fatalf("%v", err)
+				}
+			}
+		}
 	} else if args.GroupListFile != "" {
 		// 从文件读取群列表
 		groupIDs, err = readGroupListFromTS(ts, args.GroupListFile, args.RandomList)
 		if err != nil {
 			log.Fatalf("Failed to read group list from file: %v", err)
 		}
+		filename = args.GroupListFile + ".txt"
 		// 输出从文件读取到的群号数量
 		fmt.Printf("从文件%s读取了群列表,%d个群或好友\n", args.GroupListFile, len(groupIDs))
+		// 列表文件中没有群资料,需要从API获取后过滤
+		if targetFilter != nil {
+			groupIDs, err = filterListByMetadata(groupIDs, args.ApiAddress, args.Token, args.FriendMode, targetFilter)
+			if err != nil {
+				log.Fatalf("Failed to filter group list: %v", err)
+			}
+			fmt.Printf("按过滤条件'%s'筛选后剩余%d个群或好友\n", targetFilter, len(groupIDs))
+		}
+		if tags != nil {
+			groupIDs = filterByTags(groupIDs, tags)
+			fmt.Printf("按标签'%s'筛选后剩余%d个群或好友\n", args.Tags, len(groupIDs))
+		}
 	}
-	// 处理消息内容
-	message, err := handleMessageContent(ts, args.MessageContent)
+	// 读取排除列表
+	exclusions, err := exclude.Active()
 	if err != nil {
-		log.Fatalf("Error handling message content: %v", err)
+		log.Fatalf("Failed to load exclusion list: %v", err)
+	}
+	if exclusions.Len() > 0 {
+		fmt.Printf("已读取排除列表,%d条有效记录\n", exclusions.Len())
 	}
-	// 发送消息并更新保存文件
-	err = sendMessageAndUpdateSaveFile(ts, filename, args.ApiAddress, groupIDs, message, args.DelaySeconds, args.ChanceToSend, args.SaveFilePath, args.FriendMode, args.Token)
+	// 列表文件中没有名称,按名称排除时需要从API获取
+	if exclusions.HasNamePatterns() && args.GroupListFile != "" && targetFilter == nil {
+		if err := loadTargetNames(args.ApiAddress, args.Token, args.FriendMode); err != nil {
+			log.Printf("Failed to load target names, name exclusions will not apply: %v", err)
+		}
+	}
+
+	// 处理消息内容
+	var message []string
+	if args.Mode == ModeFile {
+		// 群文件模式下-w是本地文件路径,不从txt读取
+		message = []string{args.MessageContent}
+	} else if args.Mode == ModeForward {
+		// 合并转发模式下-w是节点定义的json文件
+		checkForwardFile(args.MessageContent)
+		message = []string{args.MessageContent}
+	} else if args.Mode == ModeMarkdown {
+		// markdown模式下-w是markdown消息的json文件
+		checkMarkdownFile(args.MessageContent)
+		message = []string{args.MessageContent}
+	} else {
+		message, err = handleMessageContent(ts, args.MessageContent)
+		if err != nil {
+			log.Fatalf("Error handling message content: %v", err)
+		}
+	}
+	// 发送消息并更新保存文件,开始、结束和中断都记录到审计日志,预演不记录
+	var job *jobAudit
+	if !args.DryRun {
+		job = startJobAudit(args, len(groupIDs))
+	}
+	stopWatch := job.watchCancel()
+	err = sendMessageAndUpdateSaveFile(ts, filename, groupIDs, message, exclusions, sendWindow, args)
+	stopWatch()
 	if err != nil {
+		job.end(JobFailed, err.Error())
 		log.Fatalf("Error sending messages: %v", err)
 	}
+	job.end(JobDone, "")
 }
 
-func sendGroupMessage(apiURL string, groupID int64, userID int64, message string, token string) (string, error) {
+// normalizeNewlines 将消息中的\n、字面量\\n和%0A统一替换为CRLF
+func normalizeNewlines(message string) string {
 	// 首先替换\n和%0A为占位符
 	placeholder := "\xFF\xFE"
 	message = strings.Replace(message, "\n", placeholder, -1)
@@ -334,22 +642,20 @@ func sendGroupMessage(apiURL string, groupID int64, userID int64, message string
 	crlf := []byte{13, 10}
 	byteMessage = bytes.ReplaceAll(byteMessage, []byte(placeholder), crlf)
 
+	return string(byteMessage)
+}
+
+// postAction 以POST方式调用onebot api,返回响应内容
+func postAction(apiURL string, action string, body map[string]interface{}, token string) (string, error) {
 	// 构造请求体
-	requestBody, err := json.Marshal(map[string]interface{}{
-		"group_id": groupID,
-		"message":  string(byteMessage),
-		"user_id":  userID,
-	})
+	requestBody, err := json.Marshal(body)
 	if err != nil {
 		return "", fmt.Errorf("failed to marshal request body: %w", err)
 	}
 
-	baseurl := apiURL + "/send_group_msg"
-	if token != "" {
-		baseurl += "?access_token=" + token
-	}
-	// 发送POST请求
-	resp, err := http.Post(baseurl, "application/json", bytes.NewBuffer(requestBody))
+	baseurl := apiURL + "/" + action
+	// 发送POST请求,token通过请求头发送
+	resp, err := httpclient.Post(baseurl, token, "application/json", bytes.NewBuffer(requestBody))
 	if err != nil {
 		return "", fmt.Errorf("failed to send POST request: %w", err)
 	}
@@ -370,52 +676,74 @@ func sendGroupMessage(apiURL string, groupID int64, userID int64, message string
 	return responseContent, nil
 }
 
-func sendPrivateMessage(apiURL string, userID int64, message string, token string) (string, error) {
-	// 首先替换\n和%0A为占位符
-	placeholder := "\xFF\xFE"
-	message = strings.Replace(message, "\n", placeholder, -1)
-	message = strings.Replace(message, "\\n", placeholder, -1)
-	message = strings.Replace(message, "%0A", placeholder, -1)
+// apiError onebot接口返回的调用失败
+type apiError struct {
+	RetCode int
+	Message string
+}
 
-	// 然后将字符串转换为字节切片
-	byteMessage := []byte(message)
+func (e *apiError) Error() string {
+	return fmt.Sprintf("api returned retcode %d: %s", e.RetCode, e.Message)
+}
 
-	// 替换占位符为真正的换行符字节序列（CRLF）
-	crlf := []byte{13, 10}
-	byteMessage = bytes.ReplaceAll(byteMessage, []byte(placeholder), crlf)
+// apiResponseError 检查onebot响应中的status和retcode,调用失败时返回*apiError
+func apiResponseError(responseContent string) error {
+	var response struct {
+		Status  string `json:"status"`
+		RetCode int    `json:"retcode"`
+		Message string `json:"message"`
+		Msg     string `json:"msg"`
+	}
+	if err := json.Unmarshal([]byte(responseContent), &response); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	if response.Status == "failed" || response.RetCode != 0 {
+		msg := response.Message
+		if msg == "" {
+			msg = response.Msg
+		}
+		return &apiError{RetCode: response.RetCode, Message: msg}
+	}
+	return nil
+}
 
-	// 构造请求体
-	requestBody, err := json.Marshal(map[string]interface{}{
-		"message": string(byteMessage),
+func sendGroupMessage(apiURL string, groupID int64, userID int64, message string, token string) (string, error) {
+	return postAction(apiURL, "send_group_msg", map[string]interface{}{
+		"group_id": groupID,
+		"message":  normalizeNewlines(message),
+		"user_id":  userID,
+	}, token)
+}
+
+func sendPrivateMessage(apiURL string, userID int64, message string, token string) (string, error) {
+	return postAction(apiURL, "send_private_msg", map[string]interface{}{
+		"message": normalizeNewlines(message),
 		"user_id": userID,
-	})
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal request body: %w", err)
-	}
-	baseurl := apiURL + "/send_private_msg"
-	if token != "" {
-		baseurl += "?access_token=" + token
-	}
-	// 发送POST请求
-	resp, err := http.Post(baseurl, "application/json", bytes.NewBuffer(requestBody))
-	if err != nil {
-		return "", fmt.Errorf("failed to send POST request: %w", err)
-	}
-	defer resp.Body.Close()
+	}, token)
+}
 
-	// 读取响应体
-	responseBody, err := io.ReadAll(resp.Body)
+// sendGroupNotice 发送群公告
+func sendGroupNotice(apiURL string, groupID int64, content string, token string) (string, error) {
+	return postAction(apiURL, "_send_group_notice", map[string]interface{}{
+		"group_id": groupID,
+		"content":  normalizeNewlines(content),
+	}, token)
+}
+
+// uploadGroupFile 上传群文件,filePath为本地文件路径
+func uploadGroupFile(apiURL string, groupID int64, filePath string, token string) (string, error) {
+	absPath, err := filepath.Abs(filePath)
 	if err != nil {
-		return "", fmt.Errorf("failed to read response body: %w", err)
+		return "", fmt.Errorf("failed to resolve file path: %w", err)
 	}
-	responseContent := string(responseBody)
-
-	// 检查响应状态
-	if resp.StatusCode != http.StatusOK {
-		return responseContent, fmt.Errorf("received non-OK response status: %s", resp.Status)
+	if _, err := os.Stat(absPath); err != nil {
+		return "", fmt.Errorf("failed to stat file: %w", err)
 	}
-
-	return responseContent, nil
+	return postAction(apiURL, "upload_group_file", map[string]interface{}{
+		"group_id": groupID,
+		"file":     absPath,
+		"name":     filepath.Base(absPath),
+	}, token)
 }
 
 func parseAndPossiblyRandomize(body []byte, randomlist bool) (*GroupList, error) {
@@ -452,19 +780,16 @@ func parseAndPossiblyRandomizeFriends(body []byte, randomlist bool) (*FriendList
 	return &groupList, nil
 }
 
-// 定义从HTTP API获取群列表并保存的函数，返回群列表和可能的错误
-func fetchAndSaveGroupList(apiURL string, SaveFilePath string, isgensokyo bool, token string, randomlist bool) ([]int64, string, error) {
+// fetchGroupList 从HTTP API获取群列表
+func fetchGroupList(apiURL string, token string, randomlist bool) (*GroupList, error) {
 	// 构建获取群列表的URL
 	url := apiURL + "/get_group_list"
-	if token != "" {
-		url += "?access_token=" + token
-	}
 
-	// 发送HTTP GET请求
-	resp, err := http.Get(url)
+	// 发送HTTP GET请求,token通过请求头发送
+	resp, err := httpclient.Get(url, token)
 	if err != nil {
 		log.Printf("Failed to fetch group list: %v", err)
-		return nil, "", err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
@@ -472,20 +797,44 @@ func fetchAndSaveGroupList(apiURL string, SaveFilePath string, isgensokyo bool,
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		log.Printf("Failed to read response body: %v", err)
-		return nil, "", err
+		return nil, err
 	}
 
 	// 解析JSON到结构体
 	groupList, err := parseAndPossiblyRandomize(body, randomlist)
 	if err != nil {
 		log.Println("Error processing JSON:", err)
-	} else {
-		log.Printf("Processed group list: %+v", groupList)
+		return nil, err
 	}
+	log.Printf("Processed group list: %+v", groupList)
+	saveGroupSnapshot(apiURL, token, groupList.Data)
+	return groupList, nil
+}
+
+// nopWriteCloser 预演模式下代替列表文件,丢弃写入的内容
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// createListFile 创建保存目标列表的文件,预演模式下不创建
+func createListFile(filename string) (io.WriteCloser, error) {
+	if dryRun {
+		return nopWriteCloser{io.Discard}, nil
+	}
+	return os.Create(filename)
+}
+
+// 定义从HTTP API获取群列表并保存的函数，返回群列表和可能的错误
+func fetchAndSaveGroupList(apiURL string, SaveFilePath string, isgensokyo bool, token string, randomlist bool, targetFilter *filter.Filter) ([]int64, string, error) {
+	groupList, err := fetchGroupList(apiURL, token, randomlist)
+	if err != nil {
+		return nil, "", err
+	}
+	recordGroupNames(groupList.Data)
 
 	// 创建文件以保存群列表
 	filename := fmt.Sprintf("%d-%s.txt", time.Now().Unix(), SaveFilePath)
-	file, err := os.Create(filename)
+	file, err := createListFile(filename)
 	if err != nil {
 		log.Printf("Failed to create file: %v", err)
 		return nil, "", err
@@ -497,7 +846,10 @@ func fetchAndSaveGroupList(apiURL string, SaveFilePath string, isgensokyo bool,
 	if !isgensokyo {
 		// 写入群ID到文件并收集群ID
 		for _, group := range groupList.Data {
-			_, err := file.WriteString(strconv.FormatInt(group.GroupID, 10) + "\n")
+			if !matchTarget(targetFilter, group) {
+				continue
+			}
+			_, err := io.WriteString(file, strconv.FormatInt(group.GroupID, 10)+"\n")
 			if err != nil {
 				log.Printf("Failed to write to file: %v", err)
 				return nil, "", err
@@ -511,10 +863,13 @@ func fetchAndSaveGroupList(apiURL string, SaveFilePath string, isgensokyo bool,
 		for _, group := range groupList.Data {
 			// 检查GroupName是否为空，如果为空，直接加入
 			if group.GroupName == "" {
+				lookingForSubChannel = false // 重置标记
+				if !matchTarget(targetFilter, group) {
+					continue
+				}
 				groupIDs = append(groupIDs, group.GroupID)
 				//log.Printf("GroupName为空，已添加GroupID：%d", group.GroupID)
-				lookingForSubChannel = false // 重置标记
-				_, err := file.WriteString(strconv.FormatInt(group.GroupID, 10) + "\n")
+				_, err := io.WriteString(file, strconv.FormatInt(group.GroupID, 10)+"\n")
 				if err != nil {
 					log.Printf("Failed to write to file: %v", err)
 					return nil, "", err
@@ -528,11 +883,12 @@ func fetchAndSaveGroupList(apiURL string, SaveFilePath string, isgensokyo bool,
 				lookingForSubChannel = true // 设置标记为true
 			} else if lookingForSubChannel {
 				// 仅当我们正在寻找首个子频道，并且GroupName以&开头时，才处理
-				if strings.HasPrefix(group.GroupName, "&") {
+				// 不满足过滤条件的子频道跳过,继续寻找下一个
+				if strings.HasPrefix(group.GroupName, "&") && matchTarget(targetFilter, group) {
 					groupIDs = append(groupIDs, group.GroupID)
 					log.Printf("检测到首个子频道GroupID: %d, 子频道名称: %s", group.GroupID, group.GroupName)
 					lookingForSubChannel = false // 找到后重置标记
-					_, err := file.WriteString(strconv.FormatInt(group.GroupID, 10) + "\n")
+					_, err := io.WriteString(file, strconv.FormatInt(group.GroupID, 10)+"\n")
 					if err != nil {
 						log.Printf("Failed to write to file: %v", err)
 						return nil, "", err
@@ -543,24 +899,23 @@ func fetchAndSaveGroupList(apiURL string, SaveFilePath string, isgensokyo bool,
 		}
 	}
 
-	log.Printf("Group list saved to %s\n", filename)
+	if !dryRun {
+		log.Printf("Group list saved to %s\n", filename)
+	}
 
 	return groupIDs, filename, nil // 返回群ID数组和nil表示没有错误
 }
 
-// 定义从HTTP API获取好友列表并保存的函数，返回群列表和可能的错误
-func fetchAndSaveFriendList(apiURL string, SaveFilePath string, token string, randomlist bool) ([]int64, string, error) {
-	// 构建获取群列表的URL
+// fetchFriendList 从HTTP API获取好友列表
+func fetchFriendList(apiURL string, token string, randomlist bool) (*FriendList, error) {
+	// 构建获取好友列表的URL
 	url := apiURL + "/get_friend_list"
-	if token != "" {
-		url += "?access_token=" + token
-	}
 
-	// 发送HTTP GET请求
-	resp, err := http.Get(url)
+	// 发送HTTP GET请求,token通过请求头发送
+	resp, err := httpclient.Get(url, token)
 	if err != nil {
-		log.Printf("Failed to fetch group list: %v", err)
-		return nil, "", err
+		log.Printf("Failed to fetch friend list: %v", err)
+		return nil, err
 	}
 	defer resp.Body.Close()
 
@@ -568,20 +923,31 @@ func fetchAndSaveFriendList(apiURL string, SaveFilePath string, token string, ra
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		log.Printf("Failed to read response body: %v", err)
-		return nil, "", err
+		return nil, err
 	}
 
 	// 解析JSON到结构体
-	groupList, err := parseAndPossiblyRandomizeFriends(body, randomlist)
+	friendList, err := parseAndPossiblyRandomizeFriends(body, randomlist)
 	if err != nil {
 		log.Println("Error processing JSON:", err)
-	} else {
-		log.Printf("Processed group list: %+v", groupList)
+		return nil, err
 	}
+	log.Printf("Processed friend list: %+v", friendList)
+	saveFriendSnapshot(apiURL, token, friendList.Data)
+	return friendList, nil
+}
+
+// 定义从HTTP API获取好友列表并保存的函数，返回群列表和可能的错误
+func fetchAndSaveFriendList(apiURL string, SaveFilePath string, token string, randomlist bool, targetFilter *filter.Filter) ([]int64, string, error) {
+	groupList, err := fetchFriendList(apiURL, token, randomlist)
+	if err != nil {
+		return nil, "", err
+	}
+	recordFriendNames(groupList.Data)
 
 	// 创建文件以保存群列表
 	filename := fmt.Sprintf("%d-%s.txt", time.Now().Unix(), SaveFilePath)
-	file, err := os.Create(filename)
+	file, err := createListFile(filename)
 	if err != nil {
 		log.Printf("Failed to create file: %v", err)
 		return nil, "", err
@@ -593,7 +959,10 @@ func fetchAndSaveFriendList(apiURL string, SaveFilePath string, token string, ra
 
 	// 写入群ID到文件并收集群ID
 	for _, friend := range groupList.Data {
-		_, err := file.WriteString(friend.UserID + "\n")
+		if !matchTarget(targetFilter, friend) {
+			continue
+		}
+		_, err := io.WriteString(file, friend.UserID+"\n")
 		if err != nil {
 			log.Printf("Failed to write to file: %v", err)
 			return nil, "", err
@@ -602,11 +971,100 @@ func fetchAndSaveFriendList(apiURL string, SaveFilePath string, token string, ra
 		FriendIDs = append(FriendIDs, friendid64)
 	}
 
-	log.Printf("Friends list saved to %s\n", filename)
+	if !dryRun {
+		log.Printf("Friends list saved to %s\n", filename)
+	}
 
 	return FriendIDs, filename, nil // 返回群ID数组和nil表示没有错误
 }
 
+// targetNames 记录本次任务中群名或好友昵称、备注,用于按名称排除
+var targetNames = make(map[int64][]string)
+
+// recordGroupNames 记录群名
+func recordGroupNames(groups []Group) {
+	for _, group := range groups {
+		targetNames[group.GroupID] = []string{group.GroupName}
+	}
+}
+
+// recordFriendNames 记录好友昵称和备注
+func recordFriendNames(friends []FriendData) {
+	for _, friend := range friends {
+		friendID, _ := strconv.ParseInt(friend.UserID, 10, 64)
+		targetNames[friendID] = []string{friend.Nickname, friend.Remark}
+	}
+}
+
+// loadTargetNames 从API获取群或好友资料以记录名称,用于列表文件中的目标
+func loadTargetNames(apiURL string, token string, isfriend bool) error {
+	if isfriend {
+		friendList, err := fetchFriendList(apiURL, token, false)
+		if err != nil {
+			return err
+		}
+		recordFriendNames(friendList.Data)
+		return nil
+	}
+	groupList, err := fetchGroupList(apiURL, token, false)
+	if err != nil {
+		return err
+	}
+	recordGroupNames(groupList.Data)
+	return nil
+}
+
+// matchTarget 判断群或好友是否满足过滤条件,未设置过滤条件时总是满足
+func matchTarget(targetFilter *filter.Filter, target interface{}) bool {
+	if targetFilter == nil {
+		return true
+	}
+	matched, err := targetFilter.Match(target)
+	if err != nil {
+		log.Printf("Failed to apply filter: %v", err)
+		return false
+	}
+	return matched
+}
+
+// filterListByMetadata 从API获取群或好友资料,只保留列表中满足过滤条件的ID
+func filterListByMetadata(ids []int64, apiURL string, token string, isfriend bool, targetFilter *filter.Filter) ([]int64, error) {
+	matched := make(map[int64]bool)
+	if isfriend {
+		friendList, err := fetchFriendList(apiURL, token, false)
+		if err != nil {
+			return nil, err
+		}
+		recordFriendNames(friendList.Data)
+		for _, friend := range friendList.Data {
+			if matchTarget(targetFilter, friend) {
+				friendID, _ := strconv.ParseInt(friend.UserID, 10, 64)
+				matched[friendID] = true
+			}
+		}
+	} else {
+		groupList, err := fetchGroupList(apiURL, token, false)
+		if err != nil {
+			return nil, err
+		}
+		recordGroupNames(groupList.Data)
+		for _, group := range groupList.Data {
+			if matchTarget(targetFilter, group) {
+				matched[group.GroupID] = true
+			}
+		}
+	}
+
+	// 保持原列表的顺序,资料中找不到的ID无法判断,一并去除
+	var filtered []int64
+	for _, id := range ids {
+		if matched[id] {
+			filtered = append(filtered, id)
+		}
+	}
+	return filtered, nil
+}
+
 // ts是txt单例对象，且GetFileContent方法返回一个包含文件每行内容的字符串数组和一个错误
 // readGroupListFromTS 从文本存储中读取群列表，并根据 randomlist 决定是否随机打乱
 func readGroupListFromTS(ts *txt.TxtStore, filename string, randomlist bool) ([]int64, error) {
@@ -617,18 +1075,19 @@ func readGroupListFromTS(ts *txt.TxtStore, filename string, randomlist bool) ([]
 		return nil, err
 	}
 
-	// 解析字符串数组内容为群ID列表
+	// 解析字符串数组内容为群ID列表,兼容纯ID列表和带标签、覆盖设置的标注格式
 	var groupIDs []int64
 	for _, line := range lines {
-		if line == "" {
+		target, ok, err := listfile.ParseLine(line)
+		if err != nil {
+			log.Printf("Invalid line in %s: %v", filename, err)
 			continue
 		}
-		groupID, err := strconv.ParseInt(line, 10, 64)
-		if err != nil {
-			log.Printf("Invalid group ID in content: %v", err)
+		if !ok {
 			continue
 		}
-		groupIDs = append(groupIDs, groupID)
+		recordTargetMeta(target)
+		groupIDs = append(groupIDs, target.ID)
 	}
 
 	// 如果 randomlist 为 true，则打乱 groupIDs 列表
@@ -666,58 +1125,396 @@ func handleMessageContent(ts *txt.TxtStore, content string) ([]string, error) {
 	}
 }
 
-func sendMessageAndUpdateSaveFile(ts *txt.TxtStore, filename string, apiURL string, groupIDs []int64, messages []string, delay int, chance int, saveFile string, isfriend bool, token string) error {
-	progressFilename := saveFile
-	fmt.Printf("执行发送任务,目标%d个群或好友\n", len(groupIDs))
+// messageData 是渲染消息模板时可用的数据
+type messageData struct {
+	TargetID int64 // 当前群号或好友ID
+}
+
+// messageFuncs 是消息模板中可用的函数
+var messageFuncs = template.FuncMap{
+	"image": func(path string) (string, error) {
+		return media.CQCode(path, media.KindImage)
+	},
+	"record": func(path string) (string, error) {
+		return media.CQCode(path, media.KindRecord)
+	},
+}
+
+// renderMessage 渲染消息模板,如 {{image "poster.png"}}。没有开启-template或不含模板语法的消息原样返回
+func renderMessage(message string, targetID int64) (string, error) {
+	if !templateMessages || !strings.Contains(message, "{{") {
+		return message, nil
+	}
+	tmpl, err := template.New("message").Funcs(messageFuncs).Parse(message)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse message template: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, messageData{TargetID: targetID}); err != nil {
+		return "", fmt.Errorf("failed to render message template: %w", err)
+	}
+	return buf.String(), nil
+}
+
+func sendMessageAndUpdateSaveFile(ts *txt.TxtStore, filename string, groupIDs []int64, messages []string, exclusions *exclude.List, sendWindow *window.Window, args CommandLineArgs) error {
+	// 读取已有的发送记录,用于断点续发
+	sentIDs, err := sentTargetsFromSave(ts, args.SaveFilePath)
+	if err != nil {
+		log.Printf("发送记录未创建，可能是第一次本任务。")
+	}
+	sentBefore := make(map[int64]bool, len(sentIDs))
+	for _, id := range sentIDs {
+		sentBefore[id] = true
+	}
+
+	if args.DryRun {
+		return previewSend(groupIDs, messages, exclusions, sentBefore, sendWindow, args)
+	}
+
+	workers := args.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	fmt.Printf("执行发送任务,目标%d个群或好友,%d个并发,预计完成时间%s\n", len(groupIDs), workers,
+		estimateFinish(time.Now(), len(groupIDs), sendInterval(args.DelaySeconds, args), sendWindow).Format(timeLayout))
+
+	s := &sender{
+		filename:   filename,
+		messages:   messages,
+		exclusions: exclusions,
+		sendWindow: sendWindow,
+		args:       args,
+		sentBefore: sentBefore,
+		claimed:    make(map[int64]bool),
+		rate:       ratelimit.New(args.Rate),
+		botRate:    ratelimit.NewGroup(args.BotRate),
+	}
+	targetRate = ratelimit.NewGroup(args.TargetRate)
+
+	// 按列表顺序分发给多个发送者,-workers 1 时与逐个发送相同
+	jobs := make(chan int64)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for groupID := range jobs {
+				s.send(groupID)
+			}
+		}()
+	}
 	for _, groupID := range groupIDs {
-		// 检查是否已有发送记录
-		sent, err := hasSendRecord(ts, progressFilename, groupID)
+		jobs <- groupID
+	}
+	close(jobs)
+	wg.Wait()
+	return nil
+}
+
+// sender 并发发送时共享的状态
+type sender struct {
+	filename   string
+	messages   []string
+	exclusions *exclude.List
+	sendWindow *window.Window
+	args       CommandLineArgs
+
+	mu         sync.Mutex
+	sentBefore map[int64]bool // 本次任务开始前已有发送记录的目标
+	claimed    map[int64]bool // 本次任务中已经开始处理的目标,列表中重复的目标只发送一次
+
+	rate    *ratelimit.Limiter // 全局每分钟发送上限
+	botRate *ratelimit.Group   // 每个机器人每分钟发送上限
+}
+
+// claim 标记目标开始处理,已发送或正在由其他发送者处理时返回false
+func (s *sender) claim(groupID int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sentBefore[groupID] || s.claimed[groupID] {
+		return false
+	}
+	s.claimed[groupID] = true
+	return true
+}
+
+// currentExclusions 返回最新的排除列表,exclude.json在任务运行中被修改(如用户退订)时重新读取
+func (s *sender) currentExclusions() *exclude.List {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.exclusions.Changed() {
+		list, err := exclude.Active()
+		if err != nil {
+			log.Printf("Failed to reload exclusion list: %v", err)
+		} else {
+			s.exclusions = list
+		}
+	}
+	return s.exclusions
+}
+
+// excluded 检查目标是否被排除,被排除时在进度中记录为excluded
+func (s *sender) excluded(groupID int64) bool {
+	entry, excluded := s.currentExclusions().Match(groupID, s.args.FriendMode, targetNames[groupID]...)
+	if !excluded {
+		return false
+	}
+	log.Printf("Target %d is excluded by rule %s (%s %s): %s\n", groupID, entry.ID, entry.Type, entry.Value, entry.Reason)
+	// 不记录发送时间,排除解除后断点续发和save:操作数仍把它当作未发送
+	appendSaveFile(s.filename, s.args.SaveFilePath, groupID, fmt.Sprintf("%s 规则%s %s", excludedMark, entry.ID, entry.Reason), "")
+	return true
+}
+
+// waitWindow 不在发送时段内时暂停,到时段开始后继续
+func (s *sender) waitWindow() {
+	for !s.sendWindow.Open(time.Now()) {
+		resumeAt := s.sendWindow.NextOpen(time.Now())
+		fmt.Printf("当前不在发送时段%s内,暂停到%s\n", s.sendWindow, resumeAt.Format(timeLayout))
+		time.Sleep(time.Until(resumeAt))
+	}
+}
+
+// targetRate 每个目标每分钟的发送上限,markdown降级补发时也需要等待
+var targetRate *ratelimit.Group
+
+// waitTarget 阻塞到目标允许下一次发送
+func waitTarget(isfriend bool, targetID int64) {
+	kind := "group"
+	if isfriend {
+		kind = "user"
+	}
+	targetRate.Wait(kind + ":" + strconv.FormatInt(targetID, 10))
+}
+
+// send 向单个目标发送并记录进度,可以在多个goroutine中同时调用
+func (s *sender) send(groupID int64) {
+	args := s.args
+	progressFilename := args.SaveFilePath
+
+	// 检查是否已有发送记录
+	if !s.claim(groupID) {
+		log.Printf("Message to group %d already sent, skipping\n", groupID)
+		return
+	}
+
+	// 检查排除列表,被排除的目标记录为excluded
+	if s.excluded(groupID) {
+		return
+	}
+
+	overrides, targetDelay, apiURL := resolveOverrides(groupID, args)
+	message := chooseMessage(s.messages, overrides)
+
+	// 根据概率决定是否发送
+	if rand.Intn(100) < args.ChanceToSend {
+		s.waitWindow()
+		// 先等待目标和机器人的发送上限,最后占用全局的名额,避免占着全局名额等待
+		waitTarget(args.FriendMode, groupID)
+		s.botRate.Wait(apiURL)
+		s.rate.Wait()
+		// 等待期间可能有用户退订,发送前再检查一次
+		if s.excluded(groupID) {
+			return
+		}
+
+		var sendResult string
+		rendered, err := renderForTarget(message, groupID, apiURL, args)
 		if err != nil {
-			log.Printf("发送记录未创建，可能是第一次本任务。")
+			log.Printf("Failed to render message for %d: %v\n", groupID, err)
+			sendResult = sendFailedPrefix + err.Error()
+		} else {
+			// 等待发送上限期间可能已经超出发送时段
+			s.waitWindow()
+			sendResult = sendByMode(apiURL, args.Mode, args.FriendMode, groupID, message, rendered, args.Token)
 		}
-		if sent {
-			log.Printf("Message to group %d already sent, skipping\n", groupID)
+		fmt.Printf("发送状态: %s\n", sendResult)
+
+		// 记录到保存文件
+		appendSaveFile(s.filename, progressFilename, groupID, sendResult, time.Now().Format(timeLayout))
+	} else {
+		log.Printf("Skipped sending message to group %d due to chance setting\n", groupID)
+	}
+
+	// 延迟发送下一条消息
+	time.Sleep(time.Duration(targetDelay) * time.Second)
+}
+
+// previewSend 预演模式,用虚拟时钟计算每个目标的发送时间,生成报告
+func previewSend(groupIDs []int64, messages []string, exclusions *exclude.List, sentBefore map[int64]bool, sendWindow *window.Window, args CommandLineArgs) error {
+	now := time.Now()
+	fmt.Printf("预演发送任务,目标%d个群或好友,不会发送任何消息\n", len(groupIDs))
+
+	clock := now
+	report := &PreviewReport{
+		GeneratedAt:  now.Format(timeLayout),
+		Mode:         args.Mode,
+		FriendMode:   args.FriendMode,
+		Targets:      len(groupIDs),
+		DelaySeconds: args.DelaySeconds,
+		Workers:      args.Workers,
+		Window:       args.Window,
+		StartAt:      sendWindow.NextOpen(now).Format(timeLayout),
+	}
+	seen := make(map[int64]bool, len(groupIDs))
+
+	for _, groupID := range groupIDs {
+		// 检查是否已有发送记录,列表中重复的目标只发送一次
+		if sentBefore[groupID] || seen[groupID] {
+			report.AlreadySent++
+			report.Items = append(report.Items, PreviewItem{TargetID: groupID, Action: PreviewAlreadySent})
 			continue
 		}
+		seen[groupID] = true
 
-		// 随机选择一个消息发送
-		message := messages[rand.Intn(len(messages))]
+		// 检查排除列表,被排除的目标记录为excluded
+		if entry, excluded := exclusions.Match(groupID, args.FriendMode, targetNames[groupID]...); excluded {
+			report.Excluded++
+			report.Items = append(report.Items, PreviewItem{TargetID: groupID, Action: PreviewExcluded, Error: entry.Reason})
+			continue
+		}
+
+		overrides, targetDelay, apiURL := resolveOverrides(groupID, args)
+		message := chooseMessage(messages, overrides)
+		interval := sendInterval(targetDelay, args)
 
-		var sendResult string
 		// 根据概率决定是否发送
-		if rand.Intn(100) < chance {
-			if !isfriend {
-				// 调用API发送消息
-				sendResult, err = sendGroupMessage(apiURL, groupID, 0, message, token) // UserID设置为0
-				if err != nil {
-					log.Printf("Failed to send message to group %d: %v\n", groupID, err)
-					sendResult = "失败: " + err.Error() // 记录失败状态
-				}
-				// 在发送后输出目标群和消息内容
-				fmt.Printf("正在向群号为%d的群发送消息: %s\n", groupID, message)
-			} else {
-				// 调用API发送消息
-				sendResult, err = sendPrivateMessage(apiURL, groupID, message, token) // 这里的groupID是UserID
-				if err != nil {
-					log.Printf("Failed to send message to friends %d: %v\n", groupID, err)
-					sendResult = "失败: " + err.Error() // 记录失败状态
-				}
-				// 在发送后输出目标群和消息内容
-				fmt.Printf("正在向ID号为%d的用户发送私聊消息: %s\n", groupID, message)
-			}
-			fmt.Printf("发送状态: %s\n", sendResult)
+		if rand.Intn(100) >= args.ChanceToSend {
+			report.SkippedByChance++
+			report.Items = append(report.Items, PreviewItem{TargetID: groupID, Action: PreviewSkipChance})
+			clock = clock.Add(interval)
+			continue
+		}
 
-			// 记录到保存文件
-			appendSaveFile(filename, progressFilename, groupID, sendResult, time.Now().Format("2006-01-02 15:04:05"))
+		// 不在发送时段内时顺延到时段开始
+		clock = sendWindow.NextOpen(clock)
+		item := PreviewItem{TargetID: groupID, Action: PreviewSend, ScheduledAt: clock.Format(timeLayout), Bot: overrides.Bot}
+		rendered, err := renderForTarget(message, groupID, apiURL, args)
+		if err == nil {
+			item.Message, err = previewContent(args.Mode, groupID, rendered)
+		}
+		if err != nil {
+			item.Action = PreviewError
+			item.Error = err.Error()
+			report.Errors++
 		} else {
-			log.Printf("Skipped sending message to group %d due to chance setting\n", groupID)
+			report.ToSend++
 		}
+		report.Items = append(report.Items, item)
+		clock = clock.Add(interval)
+	}
+
+	report.FinishAt = clock.Format(timeLayout)
+	return writePreviewReport(report, previewFilename(args))
+}
 
-		// 延迟发送下一条消息
-		time.Sleep(time.Duration(delay) * time.Second)
+// resolveOverrides 返回目标在列表中的覆盖设置,以及实际使用的间隔和API地址
+func resolveOverrides(groupID int64, args CommandLineArgs) (listfile.Target, int, string) {
+	overrides := targetOverrides(groupID)
+	targetDelay := args.DelaySeconds
+	if overrides.Delay >= 0 {
+		targetDelay = overrides.Delay
 	}
+	apiURL := args.ApiAddress
+	if overrides.Bot != "" {
+		apiURL = overrides.Bot
+	}
+	return overrides, targetDelay, apiURL
+}
 
-	return nil
+// chooseMessage 随机选择一个消息发送,列表中指定了msg=N时使用第N条
+func chooseMessage(messages []string, overrides listfile.Target) string {
+	message := messages[rand.Intn(len(messages))]
+	if overrides.Message > len(messages) {
+		log.Printf("Target %d: msg=%d out of range, only %d messages, using a random one\n", overrides.ID, overrides.Message, len(messages))
+	} else if overrides.Message > 0 {
+		message = messages[overrides.Message-1]
+	}
+	return message
+}
+
+// renderForTarget 渲染消息模板,本地图片和语音在这里编码为base64,需要时在消息前加上@
+func renderForTarget(message string, groupID int64, apiURL string, args CommandLineArgs) (string, error) {
+	rendered, err := renderMessage(message, groupID)
+	if err != nil {
+		return "", err
+	}
+	// 在消息前@全体成员或群主和管理员,查询失败时不@,照常发送
+	if args.Mention != MentionNone {
+		prefix, err := mentionPrefix(apiURL, args.Token, groupID, args.Mention)
+		if err != nil {
+			log.Printf("Failed to build mention for group %d, sending without it: %v\n", groupID, err)
+		}
+		rendered = prefix + rendered
+	}
+	return rendered, nil
+}
+
+// sendInterval 估算相邻两次发送的平均间隔: 每个发送者间隔delay秒,同时受全局和每个机器人的发送上限限制
+func sendInterval(delay int, args CommandLineArgs) time.Duration {
+	workers := args.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	interval := time.Duration(delay) * time.Second / time.Duration(workers)
+	if limit := ratelimit.New(args.Rate).Interval(); limit > interval {
+		interval = limit
+	}
+	if limit := ratelimit.NewGroup(args.BotRate).Interval(); limit > interval {
+		interval = limit
+	}
+	return interval
+}
+
+// sendByMode 按广播模式向单个目标发送,返回写入进度文件的发送状态
+func sendByMode(apiURL string, mode string, isfriend bool, targetID int64, message string, rendered string, token string) string {
+	var sendResult string
+	var err error
+	switch {
+	case mode == ModeNotice:
+		sendResult, err = sendGroupNotice(apiURL, targetID, rendered, token)
+		if err != nil {
+			log.Printf("Failed to send notice to group %d: %v\n", targetID, err)
+		}
+		fmt.Printf("正在向群号为%d的群发布群公告: %s\n", targetID, message)
+	case mode == ModeFile:
+		sendResult, err = uploadGroupFile(apiURL, targetID, rendered, token)
+		if err != nil {
+			log.Printf("Failed to upload file to group %d: %v\n", targetID, err)
+		}
+		fmt.Printf("正在向群号为%d的群上传群文件: %s\n", targetID, message)
+	case mode == ModeForward:
+		sendResult, err = sendForwardMessage(apiURL, targetID, isfriend, rendered, token)
+		if err != nil {
+			log.Printf("Failed to send forward message to %d: %v\n", targetID, err)
+		}
+		fmt.Printf("正在向%d发送合并转发消息: %s\n", targetID, message)
+	case mode == ModeMarkdown:
+		sendResult, err = sendMarkdownMessage(apiURL, targetID, isfriend, rendered, token)
+		if err != nil {
+			log.Printf("Failed to send markdown message to %d: %v\n", targetID, err)
+		}
+		fmt.Printf("正在向%d发送markdown消息: %s\n", targetID, message)
+	case !isfriend:
+		// 调用API发送消息
+		sendResult, err = sendGroupMessage(apiURL, targetID, 0, rendered, token) // UserID设置为0
+		if err != nil {
+			log.Printf("Failed to send message to group %d: %v\n", targetID, err)
+		}
+		// 在发送后输出目标群和消息内容
+		fmt.Printf("正在向群号为%d的群发送消息: %s\n", targetID, message)
+	default:
+		// 调用API发送消息
+		sendResult, err = sendPrivateMessage(apiURL, targetID, rendered, token) // 这里的targetID是UserID
+		if err != nil {
+			log.Printf("Failed to send message to friends %d: %v\n", targetID, err)
+		}
+		// 在发送后输出目标群和消息内容
+		fmt.Printf("正在向ID号为%d的用户发送私聊消息: %s\n", targetID, message)
+	}
+	if err != nil {
+		sendResult = sendFailedPrefix + err.Error() // 记录失败状态
+	}
+	return sendResult
 }
 
 // copyIfNeeded 检查进度文件是否存在，如果不存在则从原始群号列表复制
@@ -749,8 +1546,15 @@ func copyIfNeeded(originalFilename, progressFilename string) error {
 	return nil
 }
 
+// saveFileMu 保护进度文件的读写
+var saveFileMu sync.Mutex
+
 // appendSaveFile 用于更新进度文件
 func appendSaveFile(originalFilename, baseFilename string, groupID int64, sendResult, timestamp string) {
+	// 多个发送者同时记录时,读取和写回进度文件需要互斥
+	saveFileMu.Lock()
+	defer saveFileMu.Unlock()
+
 	progressFilename := baseFilename + "-save.txt"
 	// 确保进度文件存在，如果不存在则从原始文件复制
 	err := copyIfNeeded(originalFilename, progressFilename)
@@ -767,21 +1571,26 @@ func appendSaveFile(originalFilename, baseFilename string, groupID int64, sendRe
 	// 更新文件内容
 	groupIDStr := strconv.FormatInt(groupID, 10)
 	updated := false
+	// 去除sendResult中除了末尾以外的所有换行符
+	cleanSendResult := strings.ReplaceAll(sendResult, "\n", "")
 	for i, line := range lines {
-		if strings.HasPrefix(line, groupIDStr) {
-			// 去除sendResult中除了末尾以外的所有换行符
-			cleanSendResult := strings.ReplaceAll(sendResult, "\n", "")
-			lines[i] = fmt.Sprintf("%s %s %s", line, cleanSendResult, timestamp)
+		if id, _ := parseSaveLine(line); id == groupIDStr {
+			// 之前被排除的目标,替换排除记录
+			if at := strings.Index(line, excludedMark); at >= 0 {
+				line = strings.TrimSpace(line[:at])
+			}
+			lines[i] = strings.TrimSpace(fmt.Sprintf("%s %s %s", line, cleanSendResult, timestamp))
 			updated = true
 			break
 		}
 	}
-	// 如果找到并更新了群号，重写进度文件
-	if updated {
-		err = writeLines(lines, progressFilename)
-		if err != nil {
-			log.Printf("Failed to write updated content to progress file '%s': %v", progressFilename, err)
-		}
+	// 进度文件中没有该群号(如WebUI新建的空存档),追加一行
+	if !updated {
+		lines = append(lines, strings.TrimSpace(fmt.Sprintf("%s %s %s", groupIDStr, cleanSendResult, timestamp)))
+	}
+	err = writeLines(lines, progressFilename)
+	if err != nil {
+		log.Printf("Failed to write updated content to progress file '%s': %v", progressFilename, err)
 	}
 }
 
@@ -818,28 +1627,3 @@ func writeLines(lines []string, filename string) error {
 	}
 	return writer.Flush()
 }
-
-// hasSendRecord 检查给定群号是否已经有发送记录
-func hasSendRecord(ts *txt.TxtStore, baseFilename string, groupID int64) (bool, error) {
-	// 从TxtStore获取文件内容
-	lines, err := ts.GetFileContent(baseFilename + "-save")
-	if err != nil {
-		return false, fmt.Errorf("failed to get content from TxtStore for '%s': %v", baseFilename, err)
-	}
-
-	groupIDStr := strconv.FormatInt(groupID, 10)
-	// 正则表达式匹配 YYYY-MM-DD 格式的日期
-	dateRegex := regexp.MustCompile(`\d{4}-\d{2}-\d{2}`)
-
-	for _, line := range lines {
-		//fmt.Printf("test:%v", line)
-		if strings.HasPrefix(line, groupIDStr) {
-			// 检查这一行是否包含日期格式的字符串，即是否包含发送时间戳
-			if dateRegex.MatchString(line) {
-				return true, nil
-			}
-			break
-		}
-	}
-	return false, nil
-}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// MarkdownMessage 是markdown模式的消息文件,对应QQ开放平台的markdown和按钮
+type MarkdownMessage struct {
+	Markdown *MarkdownPayload `json:"markdown"`
+	Keyboard *KeyboardPayload `json:"keyboard,omitempty"`
+	Fallback string           `json:"fallback"` // 目标不支持markdown时发送的纯文本
+}
+
+// MarkdownPayload 原生markdown使用content,模板markdown使用custom_template_id和params
+type MarkdownPayload struct {
+	Content          string           `json:"content,omitempty"`
+	CustomTemplateID string           `json:"custom_template_id,omitempty"`
+	Params           []MarkdownParams `json:"params,omitempty"`
+}
+
+type MarkdownParams struct {
+	Key    string   `json:"key"`
+	Values []string `json:"values"`
+}
+
+// KeyboardPayload 按钮模板使用id,自定义按钮使用content
+type KeyboardPayload struct {
+	ID      string           `json:"id,omitempty"`
+	Content *KeyboardContent `json:"content,omitempty"`
+}
+
+type KeyboardContent struct {
+	Rows []KeyboardRow `json:"rows"`
+}
+
+type KeyboardRow struct {
+	Buttons []KeyboardButton `json:"buttons"`
+}
+
+type KeyboardButton struct {
+	ID         string       `json:"id,omitempty"`
+	RenderData ButtonRender `json:"render_data"`
+	Action     ButtonAction `json:"action"`
+	GroupID    string       `json:"group_id,omitempty"`
+}
+
+type ButtonRender struct {
+	Label        string `json:"label"`
+	VisitedLabel string `json:"visited_label"`
+	Style        int    `json:"style"`
+}
+
+type ButtonAction struct {
+	Type          int              `json:"type"` // 0=跳转 1=回调 2=指令
+	Permission    ButtonPermission `json:"permission"`
+	Data          string           `json:"data"`
+	Reply         bool             `json:"reply,omitempty"`
+	Enter         bool             `json:"enter,omitempty"`
+	Anchor        int              `json:"anchor,omitempty"`
+	ClickLimit    int              `json:"click_limit,omitempty"`
+	AtBotShowList bool             `json:"at_bot_show_channel_list,omitempty"`
+	UnsupportTips string           `json:"unsupport_tips,omitempty"`
+}
+
+type ButtonPermission struct {
+	Type           int      `json:"type"` // 0=指定用户 1=管理者 2=所有人 3=指定身份组
+	SpecifyUserIDs []string `json:"specify_user_ids,omitempty"`
+	SpecifyRoleIDs []string `json:"specify_role_ids,omitempty"`
+}
+
+// QQ开放平台的按钮限制
+const (
+	maxKeyboardRows    = 5
+	maxKeyboardButtons = 5
+)
+
+var (
+	markdownMessages   = make(map[string]*MarkdownMessage)
+	markdownMessagesMu sync.Mutex
+)
+
+// loadMarkdownMessage 读取并校验markdown消息文件,同一文件只解析一次
+func loadMarkdownMessage(path string) (*MarkdownMessage, error) {
+	markdownMessagesMu.Lock()
+	defer markdownMessagesMu.Unlock()
+
+	if msg, ok := markdownMessages[path]; ok {
+		return msg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read markdown message file: %w", err)
+	}
+
+	// 不允许未知字段,拼错的字段名在发送前就能发现
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var msg MarkdownMessage
+	if err := decoder.Decode(&msg); err != nil {
+		return nil, fmt.Errorf("failed to parse markdown message file: %w", err)
+	}
+	if err := msg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid markdown message file %s: %w", path, err)
+	}
+
+	markdownMessages[path] = &msg
+	return &msg, nil
+}
+
+// Validate 按QQ开放平台的规则检查markdown和按钮
+func (m *MarkdownMessage) Validate() error {
+	if m.Markdown == nil {
+		return fmt.Errorf("markdown is required")
+	}
+	if m.Fallback == "" {
+		return fmt.Errorf("fallback is required")
+	}
+
+	md := m.Markdown
+	switch {
+	case md.Content != "" && md.CustomTemplateID != "":
+		return fmt.Errorf("markdown must have either content or custom_template_id, not both")
+	case md.Content == "" && md.CustomTemplateID == "":
+		return fmt.Errorf("markdown must have content or custom_template_id")
+	case md.Content != "" && len(md.Params) > 0:
+		return fmt.Errorf("markdown params can only be used with custom_template_id")
+	}
+	for i, param := range md.Params {
+		if param.Key == "" {
+			return fmt.Errorf("markdown param %d has no key", i+1)
+		}
+		if len(param.Values) == 0 {
+			return fmt.Errorf("markdown param %s has no values", param.Key)
+		}
+	}
+
+	if m.Keyboard == nil {
+		return nil
+	}
+	kb := m.Keyboard
+	if (kb.ID == "") == (kb.Content == nil) {
+		return fmt.Errorf("keyboard must have either id or content")
+	}
+	if kb.Content == nil {
+		return nil
+	}
+	if len(kb.Content.Rows) == 0 || len(kb.Content.Rows) > maxKeyboardRows {
+		return fmt.Errorf("keyboard must have 1 to %d rows", maxKeyboardRows)
+	}
+	for i, row := range kb.Content.Rows {
+		if len(row.Buttons) == 0 || len(row.Buttons) > maxKeyboardButtons {
+			return fmt.Errorf("keyboard row %d must have 1 to %d buttons", i+1, maxKeyboardButtons)
+		}
+		for j, button := range row.Buttons {
+			if button.RenderData.Label == "" {
+				return fmt.Errorf("button %d in row %d has no label", j+1, i+1)
+			}
+			if button.Action.Type < 0 || button.Action.Type > 2 {
+				return fmt.Errorf("button %d in row %d has invalid action type %d", j+1, i+1, button.Action.Type)
+			}
+			if button.Action.Data == "" {
+				return fmt.Errorf("button %d in row %d has no action data", j+1, i+1)
+			}
+			if button.Action.Permission.Type < 0 || button.Action.Permission.Type > 3 {
+				return fmt.Errorf("button %d in row %d has invalid permission type %d", j+1, i+1, button.Action.Permission.Type)
+			}
+		}
+	}
+	return nil
+}
+
+// CQCode 将markdown和按钮编码为 [CQ:markdown,data=base64://...]
+func (m *MarkdownMessage) CQCode() (string, error) {
+	data, err := json.Marshal(struct {
+		Markdown *MarkdownPayload `json:"markdown"`
+		Keyboard *KeyboardPayload `json:"keyboard,omitempty"`
+	}{m.Markdown, m.Keyboard})
+	if err != nil {
+		return "", err
+	}
+	return "[CQ:markdown,data=base64://" + base64.StdEncoding.EncodeToString(data) + "]", nil
+}
+
+// 实现端不支持markdown消息时返回的retcode: 1400请求参数错误,1404接口不支持
+var markdownUnsupportedRetcodes = map[int]bool{1400: true, 1404: true}
+
+// markdownUnsupported 判断发送失败是否因为目标不支持markdown。网络错误、超时和限频等
+// 其他错误不降级,markdown可能已经送达,降级会让目标收到两次
+func markdownUnsupported(err error) bool {
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	if markdownUnsupportedRetcodes[apiErr.RetCode] {
+		return true
+	}
+	msg := strings.ToLower(apiErr.Message)
+	return strings.Contains(msg, "unsupported") || strings.Contains(msg, "not support") || strings.Contains(msg, "不支持")
+}
+
+// sendMarkdownMessage 发送markdown消息,目标不支持时降级为纯文本
+func sendMarkdownMessage(apiURL string, targetID int64, isfriend bool, path string, token string) (string, error) {
+	msg, err := loadMarkdownMessage(path)
+	if err != nil {
+		return "", err
+	}
+	cqcode, err := msg.CQCode()
+	if err != nil {
+		return "", err
+	}
+
+	send := func(message string) (string, error) {
+		if isfriend {
+			return sendPrivateMessage(apiURL, targetID, message, token)
+		}
+		return sendGroupMessage(apiURL, targetID, 0, message, token)
+	}
+
+	result, err := send(cqcode)
+	if err == nil {
+		err = apiResponseError(result)
+	}
+	if err == nil {
+		return result, nil
+	}
+	if !markdownUnsupported(err) {
+		return result, err
+	}
+
+	log.Printf("Markdown not supported by %d, falling back to plain text: %v\n", targetID, err)
+	fallback, err := renderMessage(msg.Fallback, targetID)
+	if err != nil {
+		return "", err
+	}
+	waitTarget(isfriend, targetID)
+	result, err = send(fallback)
+	return "降级为纯文本: " + result, err
+}
+
+// checkMarkdownFile 在任务开始前校验markdown消息文件
+func checkMarkdownFile(path string) {
+	if _, err := loadMarkdownMessage(path); err != nil {
+		log.Fatalf("Error loading markdown message file: %v", err)
+	}
+	fmt.Printf("已读取并校验markdown消息文件'%s'\n", path)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// testButton 返回一个合法按钮的json
+func testButton(label string) string {
+	return fmt.Sprintf(`{"render_data":{"label":%q},"action":{"type":2,"permission":{"type":2},"data":"/签到"}}`, label)
+}
+
+func testRow(n int) string {
+	buttons := make([]string, n)
+	for i := range buttons {
+		buttons[i] = testButton(fmt.Sprint(i + 1))
+	}
+	return `{"buttons":[` + strings.Join(buttons, ",") + `]}`
+}
+
+func testRows(n int) string {
+	list := make([]string, n)
+	for i := range list {
+		list[i] = testRow(1)
+	}
+	return `{"rows":[` + strings.Join(list, ",") + `]}`
+}
+
+func TestMarkdownValidate(t *testing.T) {
+	tests := []struct {
+		msg     string
+		wantErr string
+	}{
+		{`{"markdown":{"content":"# 标题"},"fallback":"标题"}`, ""},
+		{`{"markdown":{"custom_template_id":"101","params":[{"key":"title","values":["标题"]}]},"fallback":"标题"}`, ""},
+		{`{"markdown":{"content":"# 标题"},"keyboard":{"id":"102"},"fallback":"标题"}`, ""},
+		{`{"markdown":{"content":"# 标题"},"keyboard":{"content":` + testRows(5) + `},"fallback":"标题"}`, ""},
+		{`{"markdown":{"content":"# 标题"},"keyboard":{"content":{"rows":[` + testRow(5) + `]}},"fallback":"标题"}`, ""},
+
+		{`{"fallback":"标题"}`, "markdown is required"},
+		{`{"markdown":{"content":"# 标题"}}`, "fallback is required"},
+		{`{"markdown":{"content":"# 标题","custom_template_id":"101"},"fallback":"标题"}`, "not both"},
+		{`{"markdown":{},"fallback":"标题"}`, "must have content or custom_template_id"},
+		{`{"markdown":{"content":"# 标题","params":[{"key":"a","values":["b"]}]},"fallback":"标题"}`, "params can only be used"},
+		{`{"markdown":{"custom_template_id":"101","params":[{"values":["b"]}]},"fallback":"标题"}`, "param 1 has no key"},
+		{`{"markdown":{"custom_template_id":"101","params":[{"key":"a","values":[]}]},"fallback":"标题"}`, "param a has no values"},
+		{`{"markdown":{"content":"# 标题"},"keyboard":{},"fallback":"标题"}`, "either id or content"},
+		{`{"markdown":{"content":"# 标题"},"keyboard":{"id":"102","content":` + testRows(1) + `},"fallback":"标题"}`, "either id or content"},
+		{`{"markdown":{"content":"# 标题"},"keyboard":{"content":{"rows":[]}},"fallback":"标题"}`, "1 to 5 rows"},
+		{`{"markdown":{"content":"# 标题"},"keyboard":{"content":` + testRows(6) + `},"fallback":"标题"}`, "1 to 5 rows"},
+		{`{"markdown":{"content":"# 标题"},"keyboard":{"content":{"rows":[` + testRow(6) + `]}},"fallback":"标题"}`, "row 1 must have 1 to 5 buttons"},
+		{`{"markdown":{"content":"# 标题"},"keyboard":{"content":{"rows":[{"buttons":[]}]}},"fallback":"标题"}`, "row 1 must have 1 to 5 buttons"},
+		{`{"markdown":{"content":"# 标题"},"keyboard":{"content":{"rows":[` + testRow(1) + `,{"buttons":[` + testButton("") + `]}]}},"fallback":"标题"}`, "button 1 in row 2 has no label"},
+		{`{"markdown":{"content":"# 标题"},"keyboard":{"content":{"rows":[{"buttons":[{"render_data":{"label":"a"},"action":{"type":3,"data":"x"}}]}]}},"fallback":"标题"}`, "invalid action type 3"},
+		{`{"markdown":{"content":"# 标题"},"keyboard":{"content":{"rows":[{"buttons":[{"render_data":{"label":"a"},"action":{"type":0}}]}]}},"fallback":"标题"}`, "no action data"},
+		{`{"markdown":{"content":"# 标题"},"keyboard":{"content":{"rows":[{"buttons":[{"render_data":{"label":"a"},"action":{"type":0,"data":"x","permission":{"type":4}}}]}]}},"fallback":"标题"}`, "invalid permission type 4"},
+	}
+	for _, tt := range tests {
+		var msg MarkdownMessage
+		if err := json.Unmarshal([]byte(tt.msg), &msg); err != nil {
+			t.Fatalf("bad test message %s: %v", tt.msg, err)
+		}
+		err := msg.Validate()
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("Validate(%s): %v", tt.msg, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("Validate(%s) = %v, want error containing %q", tt.msg, err, tt.wantErr)
+		}
+	}
+}
+
+func TestMarkdownCQCode(t *testing.T) {
+	msg := MarkdownMessage{
+		Markdown: &MarkdownPayload{Content: "# 标题"},
+		Keyboard: &KeyboardPayload{ID: "102"},
+		Fallback: "标题",
+	}
+	cqcode, err := msg.CQCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	const prefix, suffix = "[CQ:markdown,data=base64://", "]"
+	if !strings.HasPrefix(cqcode, prefix) || !strings.HasSuffix(cqcode, suffix) {
+		t.Fatalf("CQCode = %s", cqcode)
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSuffix(strings.TrimPrefix(cqcode, prefix), suffix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 纯文本降级内容不发送给实现端
+	if got, want := string(data), `{"markdown":{"content":"# 标题"},"keyboard":{"id":"102"}}`; got != want {
+		t.Errorf("CQCode data = %s, want %s", got, want)
+	}
+}
+
+func TestMarkdownUnsupported(t *testing.T) {
+	tests := []struct {
+		response string
+		want     bool
+	}{
+		{`{"status":"failed","retcode":1404,"msg":"不支持的接口"}`, true},
+		{`{"status":"failed","retcode":1400}`, true},
+		{`{"status":"failed","retcode":100,"message":"markdown not supported"}`, true},
+		{`{"status":"failed","retcode":100,"message":"Unsupported message type"}`, true},
+		{`{"status":"failed","retcode":100,"msg":"消息类型不支持"}`, true},
+		// 其他错误不降级,markdown可能已经送达
+		{`{"status":"failed","retcode":100,"msg":"群不存在"}`, false},
+		{`{"status":"failed","retcode":1200,"msg":"timeout"}`, false},
+	}
+	for _, tt := range tests {
+		if got := markdownUnsupported(apiResponseError(tt.response)); got != tt.want {
+			t.Errorf("markdownUnsupported(%s) = %v, want %v", tt.response, got, tt.want)
+		}
+	}
+	// 只根据实现端返回的错误判断,网络错误不降级
+	errs := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{errors.New("failed to send POST request: not supported"), false},
+		{fmt.Errorf("send: %w", &apiError{RetCode: 1404}), true},
+	}
+	for _, tt := range errs {
+		if got := markdownUnsupported(tt.err); got != tt.want {
+			t.Errorf("markdownUnsupported(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
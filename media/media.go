@@ -0,0 +1,139 @@
+package media
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	KindImage  = "image"
+	KindRecord = "record"
+
+	MaxImageSize  = 10 * 1024 * 1024 // 图片最大10MB
+	MaxRecordSize = 20 * 1024 * 1024 // 语音最大20MB
+)
+
+// cacheEntry 缓存已编码的文件,文件大小或修改时间变化时失效
+type cacheEntry struct {
+	size    int64
+	modTime time.Time
+	encoded string
+}
+
+var (
+	cache   = make(map[string]cacheEntry)
+	cacheMu sync.RWMutex
+)
+
+var ErrOutsideDir = errors.New("file must be in the working directory or the program directory")
+
+// allowedDirs 返回可以引用文件的目录:当前目录和程序所在目录
+func allowedDirs() []string {
+	var dirs []string
+	if wd, err := os.Getwd(); err == nil {
+		dirs = append(dirs, wd)
+	}
+	if executablePath, err := os.Executable(); err == nil {
+		dirs = append(dirs, filepath.Dir(executablePath))
+	}
+	return dirs
+}
+
+// inDir 判断path是否在dir或其子目录中
+func inDir(path string, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// checkPath 只允许引用当前目录或程序目录下的文件,符号链接按实际路径检查,
+// 避免消息模板读取并发送系统中的其他文件
+func checkPath(path string, absPath string) error {
+	realPath, err := filepath.EvalSymlinks(absPath)
+	if err != nil {
+		return err
+	}
+	for _, dir := range allowedDirs() {
+		if realDir, err := filepath.EvalSymlinks(dir); err == nil && inDir(realPath, realDir) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrOutsideDir, path)
+}
+
+// maxSizeOf 返回对应类型允许的最大文件大小
+func maxSizeOf(kind string) (int64, error) {
+	switch kind {
+	case KindImage:
+		return MaxImageSize, nil
+	case KindRecord:
+		return MaxRecordSize, nil
+	default:
+		return 0, fmt.Errorf("unsupported media kind: %s", kind)
+	}
+}
+
+// Encode 读取当前目录或程序目录下的文件,检查大小并编码为 base64:// 形式,结果会被缓存
+func Encode(path string, kind string) (string, error) {
+	maxSize, err := maxSizeOf(kind)
+	if err != nil {
+		return "", err
+	}
+
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	info, err := os.Stat(absPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+	if info.IsDir() {
+		return "", fmt.Errorf("%s is a directory", path)
+	}
+	if err := checkPath(path, absPath); err != nil {
+		return "", err
+	}
+	if info.Size() > maxSize {
+		return "", fmt.Errorf("%s is too large: %d bytes, limit %d bytes", path, info.Size(), maxSize)
+	}
+
+	// 命中缓存则直接返回,避免大批量任务重复读取和编码
+	cacheMu.RLock()
+	entry, ok := cache[absPath]
+	cacheMu.RUnlock()
+	if ok && entry.size == info.Size() && entry.modTime.Equal(info.ModTime()) {
+		return entry.encoded, nil
+	}
+
+	data, err := os.ReadFile(absPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	encoded := "base64://" + base64.StdEncoding.EncodeToString(data)
+
+	cacheMu.Lock()
+	cache[absPath] = cacheEntry{
+		size:    info.Size(),
+		modTime: info.ModTime(),
+		encoded: encoded,
+	}
+	cacheMu.Unlock()
+
+	return encoded, nil
+}
+
+// CQCode 将本地文件编码为对应类型的CQ码,如 [CQ:image,file=base64://...]
+func CQCode(path string, kind string) (string, error) {
+	encoded, err := Encode(path, kind)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("[CQ:%s,file=%s]", kind, encoded), nil
+}
@@ -0,0 +1,79 @@
+package media
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// inTempDir 切换到临时目录,返回临时目录的路径
+func inTempDir(t *testing.T) string {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	return dir
+}
+
+func TestEncode(t *testing.T) {
+	dir := inTempDir(t)
+	if err := os.MkdirAll("img", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join("img", "poster.png"), []byte("png"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"img/poster.png", filepath.Join(dir, "img", "poster.png"), "img/../img/poster.png"} {
+		encoded, err := Encode(path, KindImage)
+		if err != nil {
+			t.Errorf("Encode(%s): %v", path, err)
+		} else if encoded != "base64://cG5n" {
+			t.Errorf("Encode(%s) = %s", path, encoded)
+		}
+	}
+	if _, err := Encode("img", KindImage); err == nil {
+		t.Error("encoded a directory")
+	}
+	if _, err := Encode("img/poster.png", "video"); err == nil {
+		t.Error("encoded an unsupported kind")
+	}
+}
+
+func TestEncodeOutsideDir(t *testing.T) {
+	outside := t.TempDir()
+	secret := filepath.Join(outside, "secret.txt")
+	if err := os.WriteFile(secret, []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dir := inTempDir(t)
+	if err := os.Mkdir("sub", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir("sub"); err != nil {
+		t.Fatal(err)
+	}
+
+	rel, err := filepath.Rel(filepath.Join(dir, "sub"), secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := []string{secret, rel}
+	// 符号链接按实际路径检查
+	if err := os.Symlink(secret, "link.png"); err == nil {
+		paths = append(paths, "link.png")
+	}
+	for _, path := range paths {
+		_, err := Encode(path, KindImage)
+		if !errors.Is(err, ErrOutsideDir) {
+			t.Errorf("Encode(%s) = %v, want %v", path, err, ErrOutsideDir)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+// -at 的取值
+const (
+	MentionNone   = ""       // 不@任何人
+	MentionAll    = "all"    // 机器人是管理员且还有次数时@全体成员,否则@群主和管理员
+	MentionAdmins = "admins" // @群主和管理员
+)
+
+// 群成员角色
+const (
+	roleOwner  = "owner"
+	roleAdmin  = "admin"
+	roleMember = "member"
+)
+
+type groupMember struct {
+	UserID json.Number `json:"user_id"`
+	Role   string      `json:"role"`
+}
+
+// memberKey 不同机器人(bot=覆盖设置)在同一个群的身份不同,缓存时需要区分
+type memberKey struct {
+	apiURL  string
+	groupID int64
+}
+
+// 本次任务中群成员查询的缓存,避免每个群重复查询,多个发送者共用
+var (
+	memberCacheMu    sync.Mutex
+	botRoleCache     = make(map[memberKey]string)
+	groupAdminsCache = make(map[memberKey][]string)
+)
+
+// mentionPrefix 返回消息前要加上的@,mode为MentionAll或MentionAdmins
+func mentionPrefix(apiURL string, token string, groupID int64, mode string) (string, error) {
+	if mode == MentionAll {
+		canAtAll, err := canMentionAll(apiURL, token, groupID)
+		if err != nil {
+			return "", err
+		}
+		if canAtAll {
+			return "[CQ:at,qq=all] ", nil
+		}
+	}
+
+	admins, err := fetchGroupAdmins(apiURL, token, groupID)
+	if err != nil {
+		return "", err
+	}
+	var prefix strings.Builder
+	for _, userID := range admins {
+		prefix.WriteString(fmt.Sprintf("[CQ:at,qq=%s] ", userID))
+	}
+	return prefix.String(), nil
+}
+
+// canMentionAll 检查机器人是否为群主或管理员,以及@全体成员的剩余次数
+func canMentionAll(apiURL string, token string, groupID int64) (bool, error) {
+	role, err := fetchBotRole(apiURL, token, groupID)
+	if err != nil {
+		return false, err
+	}
+	if role != roleOwner && role != roleAdmin {
+		log.Printf("Bot is %s in group %d, mentioning owner and admins instead of all\n", role, groupID)
+		return false, nil
+	}
+
+	// get_group_at_all_remain 是go-cqhttp的扩展接口,不支持时按有次数处理
+	responseContent, err := postAction(apiURL, "get_group_at_all_remain", map[string]interface{}{
+		"group_id": groupID,
+	}, token)
+	if err == nil {
+		err = apiResponseError(responseContent)
+	}
+	if err != nil {
+		log.Printf("Failed to get @all remain for group %d, assuming available: %v\n", groupID, err)
+		return true, nil
+	}
+	var response struct {
+		Data struct {
+			CanAtAll bool `json:"can_at_all"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(responseContent), &response); err != nil {
+		return false, fmt.Errorf("failed to parse @all remain: %w", err)
+	}
+	if !response.Data.CanAtAll {
+		log.Printf("@all quota exhausted in group %d, mentioning owner and admins instead\n", groupID)
+	}
+	return response.Data.CanAtAll, nil
+}
+
+// fetchBotRole 通过get_group_member_info获取机器人在群中的角色
+func fetchBotRole(apiURL string, token string, groupID int64) (string, error) {
+	key := memberKey{apiURL, groupID}
+	memberCacheMu.Lock()
+	role, ok := botRoleCache[key]
+	memberCacheMu.Unlock()
+	if ok {
+		return role, nil
+	}
+	bot, err := fetchBotInfo(apiURL, token)
+	if err != nil {
+		return "", err
+	}
+	responseContent, err := postAction(apiURL, "get_group_member_info", map[string]interface{}{
+		"group_id": groupID,
+		"user_id":  json.Number(bot.ID),
+		"no_cache": false,
+	}, token)
+	if err == nil {
+		err = apiResponseError(responseContent)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get bot role in group %d: %w", groupID, err)
+	}
+	var response struct {
+		Data groupMember `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(responseContent), &response); err != nil {
+		return "", fmt.Errorf("failed to parse member info: %w", err)
+	}
+	role = response.Data.Role
+	if role == "" {
+		role = roleMember
+	}
+	memberCacheMu.Lock()
+	botRoleCache[key] = role
+	memberCacheMu.Unlock()
+	return role, nil
+}
+
+// fetchGroupAdmins 通过get_group_member_list获取群主和管理员,群主在前,不包括机器人自己
+func fetchGroupAdmins(apiURL string, token string, groupID int64) ([]string, error) {
+	key := memberKey{apiURL, groupID}
+	memberCacheMu.Lock()
+	admins, ok := groupAdminsCache[key]
+	memberCacheMu.Unlock()
+	if ok {
+		return admins, nil
+	}
+	bot, err := fetchBotInfo(apiURL, token)
+	if err != nil {
+		return nil, err
+	}
+	responseContent, err := postAction(apiURL, "get_group_member_list", map[string]interface{}{
+		"group_id": groupID,
+	}, token)
+	if err == nil {
+		err = apiResponseError(responseContent)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get member list of group %d: %w", groupID, err)
+	}
+	var response struct {
+		Data []groupMember `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(responseContent), &response); err != nil {
+		return nil, fmt.Errorf("failed to parse member list: %w", err)
+	}
+
+	memberCacheMu.Lock()
+	defer memberCacheMu.Unlock()
+	var owners, managers []string
+	for _, member := range response.Data {
+		userID := member.UserID.String()
+		if userID == bot.ID {
+			// 顺便记录机器人的角色
+			botRoleCache[key] = member.Role
+			continue
+		}
+		switch member.Role {
+		case roleOwner:
+			owners = append(owners, userID)
+		case roleAdmin:
+			managers = append(managers, userID)
+		}
+	}
+	result := append(owners, managers...)
+	groupAdminsCache[key] = result
+	return result, nil
+}
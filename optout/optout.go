@@ -0,0 +1,224 @@
+package optout
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hoshinonyaruko/gensokyo-broadcast/audit"
+	"github.com/hoshinonyaruko/gensokyo-broadcast/config"
+	"github.com/hoshinonyaruko/gensokyo-broadcast/exclude"
+	"golang.org/x/net/websocket"
+)
+
+// Event onebot上报事件中退订需要用到的字段
+type Event struct {
+	PostType    string          `json:"post_type"`
+	MessageType string          `json:"message_type"`
+	SelfID      json.Number     `json:"self_id"`
+	UserID      json.Number     `json:"user_id"`
+	GroupID     json.Number     `json:"group_id"`
+	RawMessage  string          `json:"raw_message"`
+	Message     json.RawMessage `json:"message"`
+	Sender      struct {
+		Role string `json:"role"`
+	} `json:"sender"`
+}
+
+// cqCode 匹配CQ码,群内退订时通常会@机器人
+var cqCode = regexp.MustCompile(`\[CQ:[^\]]*\]`)
+
+// text 返回去掉CQ码后的纯文本
+func (e *Event) text() string {
+	message := e.RawMessage
+	if message == "" {
+		// message为字符串格式时直接使用,数组格式时没有raw_message则无法判断
+		var s string
+		if err := json.Unmarshal(e.Message, &s); err == nil {
+			message = s
+		}
+	}
+	return strings.TrimSpace(cqCode.ReplaceAllString(message, ""))
+}
+
+// Handle 检查事件是否为退订请求,是则加入排除列表,返回需要回复的内容
+func Handle(cfg config.Config, event *Event, source string) (string, bool) {
+	if event.PostType != "message" {
+		return "", false
+	}
+
+	text := event.text()
+	keyword := ""
+	for _, k := range cfg.OptOutKeywords {
+		if k != "" && text == k {
+			keyword = k
+			break
+		}
+	}
+	if keyword == "" {
+		return "", false
+	}
+
+	var entryType, value string
+	switch event.MessageType {
+	case "private":
+		entryType, value = exclude.TypeUser, event.UserID.String()
+	case "group":
+		// 只有群主和管理员可以为整个群退订
+		if event.Sender.Role != "owner" && event.Sender.Role != "admin" {
+			log.Printf("Ignored opt-out from non-admin %s in group %s", event.UserID, event.GroupID)
+			return "", false
+		}
+		entryType, value = exclude.TypeGroup, event.GroupID.String()
+	default:
+		return "", false
+	}
+	if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+		return "", false
+	}
+
+	// 已经在排除列表中的不重复添加
+	active, err := exclude.Active()
+	if err != nil {
+		log.Printf("Failed to load exclusion list: %v", err)
+		return "", false
+	}
+	id, _ := strconv.ParseInt(value, 10, 64)
+	if _, excluded := active.Match(id, entryType == exclude.TypeUser); !excluded {
+		entry, err := exclude.Add(exclude.Entry{
+			Type:   entryType,
+			Value:  value,
+			Reason: fmt.Sprintf("用户%s发送退订关键词'%s'", event.UserID, keyword),
+			Source: source,
+		})
+		if err != nil {
+			log.Printf("Failed to add opt-out exclusion: %v", err)
+			return "", false
+		}
+		log.Printf("Opt-out: added exclusion %s for %s %s", entry.ID, entryType, value)
+	}
+
+	audit.Record(audit.Entry{
+		Action: "optout",
+		User:   "onebot:" + event.UserID.String(),
+		Detail: map[string]interface{}{
+			"type":         entryType,
+			"target":       value,
+			"keyword":      keyword,
+			"message_type": event.MessageType,
+			"self_id":      event.SelfID.String(),
+			"source":       source,
+		},
+	})
+
+	if !cfg.OptOutConfirm {
+		return "", false
+	}
+	return cfg.OptOutReply, true
+}
+
+// verifySignature 校验onebot http上报的X-Signature
+func verifySignature(secret string, signature string, body []byte) bool {
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write(body)
+	expected := "sha1=" + hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+// HTTPHandler 接收onebot的http post上报,退订的回复使用快速操作返回
+func HTTPHandler(cfg config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.Status(http.StatusBadRequest)
+			return
+		}
+		// 没有设置密钥时任何人都能伪造退订,拒绝所有上报
+		if cfg.EventSecret == "" || !verifySignature(cfg.EventSecret, c.GetHeader("X-Signature"), body) {
+			c.Status(http.StatusUnauthorized)
+			return
+		}
+
+		var event Event
+		if err := json.Unmarshal(body, &event); err != nil {
+			c.Status(http.StatusBadRequest)
+			return
+		}
+
+		reply, ok := Handle(cfg, &event, "http")
+		if !ok {
+			c.Status(http.StatusNoContent)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"reply": reply, "at_sender": event.MessageType == "group"})
+	}
+}
+
+// WebSocketHandler 接收onebot反向websocket的事件,退订的回复通过同一连接调用send_msg
+func WebSocketHandler(cfg config.Config) gin.HandlerFunc {
+	server := websocket.Server{
+		// onebot实现端不是浏览器,不检查Origin,改为检查access_token
+		Handshake: func(wsConfig *websocket.Config, req *http.Request) error {
+			if cfg.EventToken == "" {
+				return fmt.Errorf("eventToken is not set")
+			}
+			token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+			if token == "" {
+				token = req.URL.Query().Get("access_token")
+			}
+			if subtle.ConstantTimeCompare([]byte(token), []byte(cfg.EventToken)) != 1 {
+				return fmt.Errorf("invalid access token")
+			}
+			return nil
+		},
+		Handler: func(conn *websocket.Conn) {
+			defer conn.Close()
+			for {
+				var data []byte
+				if err := websocket.Message.Receive(conn, &data); err != nil {
+					if err != io.EOF {
+						log.Printf("Event websocket closed: %v", err)
+					}
+					return
+				}
+
+				var event Event
+				if err := json.Unmarshal(data, &event); err != nil {
+					continue
+				}
+				reply, ok := Handle(cfg, &event, "websocket")
+				if !ok {
+					continue
+				}
+
+				params := map[string]interface{}{
+					"message_type": event.MessageType,
+					"message":      reply,
+				}
+				if event.MessageType == "group" {
+					params["group_id"] = event.GroupID
+				} else {
+					params["user_id"] = event.UserID
+				}
+				if err := websocket.JSON.Send(conn, map[string]interface{}{
+					"action": "send_msg",
+					"params": params,
+					"echo":   "optout",
+				}); err != nil {
+					log.Printf("Failed to send opt-out reply: %v", err)
+				}
+			}
+		},
+	}
+	return gin.WrapH(server)
+}
@@ -0,0 +1,159 @@
+package optout
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hoshinonyaruko/gensokyo-broadcast/config"
+	"github.com/hoshinonyaruko/gensokyo-broadcast/exclude"
+)
+
+// inTempDir 切换到临时目录,排除列表和审计日志写在当前目录下
+func inTempDir(t *testing.T) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write(body)
+	return "sha1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+var testConfig = config.Config{
+	OptOutEnabled:  true,
+	OptOutKeywords: []string{"退订", "TD"},
+	OptOutConfirm:  true,
+	OptOutReply:    "已退订",
+	EventSecret:    "secret",
+}
+
+func TestVerifySignature(t *testing.T) {
+	body := []byte(`{"post_type":"message"}`)
+	tests := []struct {
+		secret    string
+		signature string
+		want      bool
+	}{
+		{"secret", sign("secret", body), true},
+		{"secret", sign("other", body), false},
+		{"secret", strings.TrimPrefix(sign("secret", body), "sha1="), false},
+		{"secret", sign("secret", []byte(`{"post_type":"notice"}`)), false},
+		{"secret", "", false},
+	}
+	for _, tt := range tests {
+		if got := verifySignature(tt.secret, tt.signature, body); got != tt.want {
+			t.Errorf("verifySignature(%q, %q) = %v, want %v", tt.secret, tt.signature, got, tt.want)
+		}
+	}
+}
+
+func TestText(t *testing.T) {
+	tests := []struct {
+		event string
+		want  string
+	}{
+		{`{"raw_message":"[CQ:at,qq=10001] 退订"}`, "退订"},
+		{`{"raw_message":" 退订[CQ:face,id=1] "}`, "退订"},
+		// 没有raw_message时使用字符串格式的message
+		{`{"message":"[CQ:reply,id=5]退订"}`, "退订"},
+		{`{"message":[{"type":"text","data":{"text":"退订"}}]}`, ""},
+	}
+	for _, tt := range tests {
+		var event Event
+		if err := json.Unmarshal([]byte(tt.event), &event); err != nil {
+			t.Fatal(err)
+		}
+		if got := event.text(); got != tt.want {
+			t.Errorf("text(%s) = %q, want %q", tt.event, got, tt.want)
+		}
+	}
+}
+
+func TestHandle(t *testing.T) {
+	tests := []struct {
+		name     string
+		event    string
+		ok       bool
+		excluded int64
+		isUser   bool
+	}{
+		{"private", `{"post_type":"message","message_type":"private","user_id":20001,"raw_message":"退订"}`, true, 20001, true},
+		{"trimmed", `{"post_type":"message","message_type":"private","user_id":20002,"raw_message":" TD "}`, true, 20002, true},
+		// 关键词需要完全匹配
+		{"contains keyword", `{"post_type":"message","message_type":"private","user_id":20003,"raw_message":"我要退订"}`, false, 20003, true},
+		{"case", `{"post_type":"message","message_type":"private","user_id":20004,"raw_message":"td"}`, false, 20004, true},
+		{"admin", `{"post_type":"message","message_type":"group","user_id":20005,"group_id":30001,"raw_message":"[CQ:at,qq=10001] 退订","sender":{"role":"admin"}}`, true, 30001, false},
+		{"owner", `{"post_type":"message","message_type":"group","user_id":20006,"group_id":30002,"raw_message":"退订","sender":{"role":"owner"}}`, true, 30002, false},
+		// 普通成员不能为整个群退订
+		{"member", `{"post_type":"message","message_type":"group","user_id":20007,"group_id":30003,"raw_message":"[CQ:at,qq=10001] 退订","sender":{"role":"member"}}`, false, 30003, false},
+		{"no role", `{"post_type":"message","message_type":"group","user_id":20008,"group_id":30004,"raw_message":"退订"}`, false, 30004, false},
+		{"notice", `{"post_type":"notice","message_type":"private","user_id":20009,"raw_message":"退订"}`, false, 20009, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inTempDir(t)
+			var event Event
+			if err := json.Unmarshal([]byte(tt.event), &event); err != nil {
+				t.Fatal(err)
+			}
+			reply, ok := Handle(testConfig, &event, "http")
+			if ok != tt.ok || (ok && reply != testConfig.OptOutReply) {
+				t.Errorf("Handle = %q, %v, want ok %v", reply, ok, tt.ok)
+			}
+			active, err := exclude.Active()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, excluded := active.Match(tt.excluded, tt.isUser); excluded != tt.ok {
+				t.Errorf("%d excluded = %v, want %v", tt.excluded, excluded, tt.ok)
+			}
+		})
+	}
+}
+
+func TestHTTPHandler(t *testing.T) {
+	inTempDir(t)
+	gin.SetMode(gin.TestMode)
+	body := []byte(`{"post_type":"message","message_type":"private","user_id":20001,"raw_message":"退订"}`)
+
+	noSecret := testConfig
+	noSecret.EventSecret = ""
+	tests := []struct {
+		cfg       config.Config
+		signature string
+		want      int
+	}{
+		// 没有设置密钥时拒绝所有上报
+		{noSecret, sign("", body), http.StatusUnauthorized},
+		{testConfig, "", http.StatusUnauthorized},
+		{testConfig, sign("other", body), http.StatusUnauthorized},
+		{testConfig, sign("secret", body), http.StatusOK},
+	}
+	for _, tt := range tests {
+		r := gin.New()
+		r.POST("/onebot/event", HTTPHandler(tt.cfg))
+		req := httptest.NewRequest(http.MethodPost, "/onebot/event", strings.NewReader(string(body)))
+		req.Header.Set("X-Signature", tt.signature)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("signature %q: status %d, want %d", tt.signature, w.Code, tt.want)
+		}
+	}
+}
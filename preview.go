@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/hoshinonyaruko/gensokyo-broadcast/window"
+)
+
+// 预演报告中每个目标的处理结果
+const (
+	PreviewSend        = "send"         // 会发送
+	PreviewAlreadySent = "already_sent" // 存档中已有发送记录,跳过
+	PreviewSkipChance  = "skip_chance"  // 因推送概率跳过
+	PreviewExcluded    = "excluded"     // 在排除列表中
+	PreviewError       = "error"        // 渲染失败,实际运行时会记录为失败
+)
+
+// PreviewItem 预演报告中的单个目标
+type PreviewItem struct {
+	TargetID    int64  `json:"target_id"`
+	Action      string `json:"action"`
+	ScheduledAt string `json:"scheduled_at,omitempty"`
+	Bot         string `json:"bot,omitempty"` // 列表中用bot=指定的API地址
+	Message     string `json:"message,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// PreviewReport 预演模式(-n)生成的报告,不调用任何send_*接口,也不写入-save进度文件
+type PreviewReport struct {
+	GeneratedAt     string        `json:"generated_at"`
+	Mode            string        `json:"mode"`
+	FriendMode      bool          `json:"friend_mode"`
+	Targets         int           `json:"targets"`
+	ToSend          int           `json:"to_send"`
+	AlreadySent     int           `json:"already_sent"`
+	SkippedByChance int           `json:"skipped_by_chance"`
+	Excluded        int           `json:"excluded"`
+	Errors          int           `json:"errors"`
+	DelaySeconds    int           `json:"delay_seconds"`
+	Workers         int           `json:"workers"`
+	Window          string        `json:"window,omitempty"`
+	StartAt         string        `json:"start_at"`
+	FinishAt        string        `json:"finish_at"`
+	Items           []PreviewItem `json:"items"`
+}
+
+const timeLayout = "2006-01-02 15:04:05"
+
+// base64Payload 匹配消息中的base64内容,预览时只显示长度
+var base64Payload = regexp.MustCompile(`base64://[A-Za-z0-9+/=]+`)
+
+// elideBase64 将base64内容替换为长度说明,避免报告被图片数据撑大
+func elideBase64(message string) string {
+	return base64Payload.ReplaceAllStringFunc(message, func(payload string) string {
+		return fmt.Sprintf("base64://(%d bytes)", len(payload)-len("base64://"))
+	})
+}
+
+// previewContent 渲染单个目标实际会发送的内容
+func previewContent(mode string, targetID int64, rendered string) (string, error) {
+	switch mode {
+	case ModeForward:
+		nodes, err := loadForwardNodes(rendered)
+		if err != nil {
+			return "", err
+		}
+		messages, err := buildForwardMessages(nodes, targetID)
+		if err != nil {
+			return "", err
+		}
+		data, err := json.Marshal(messages)
+		if err != nil {
+			return "", err
+		}
+		return elideBase64(string(data)), nil
+	case ModeMarkdown:
+		msg, err := loadMarkdownMessage(rendered)
+		if err != nil {
+			return "", err
+		}
+		data, err := json.Marshal(msg)
+		if err != nil {
+			return "", err
+		}
+		return elideBase64(string(data)), nil
+	default:
+		return elideBase64(rendered), nil
+	}
+}
+
+// estimateFinish 估算发送count个目标、平均间隔interval的完成时间,不在发送时段内的时间不计入
+func estimateFinish(start time.Time, count int, interval time.Duration, sendWindow *window.Window) time.Time {
+	if sendWindow == nil {
+		return start.Add(time.Duration(count) * interval)
+	}
+	clock := start
+	for i := 0; i < count; i++ {
+		clock = sendWindow.NextOpen(clock).Add(interval)
+	}
+	return clock
+}
+
+// previewFilename 返回预演报告的文件名,没有用-report指定时为 存档名-preview.json
+func previewFilename(args CommandLineArgs) string {
+	if args.ReportFile != "" {
+		return args.ReportFile
+	}
+	return args.SaveFilePath + "-preview.json"
+}
+
+// writePreviewReport 将预演报告写入文件并输出摘要
+func writePreviewReport(report *PreviewReport, filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("failed to create preview report: %w", err)
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "    ")
+	if err := encoder.Encode(report); err != nil {
+		return fmt.Errorf("failed to write preview report: %w", err)
+	}
+
+	fmt.Printf("预演完成,共%d个目标: 将发送%d个,已发送跳过%d个,概率跳过%d个,已排除%d个,渲染失败%d个\n",
+		report.Targets, report.ToSend, report.AlreadySent, report.SkippedByChance, report.Excluded, report.Errors)
+	fmt.Printf("预计开始时间%s,预计完成时间%s\n", report.StartAt, report.FinishAt)
+	fmt.Printf("预演报告已保存到'%s'\n", filename)
+	return nil
+}
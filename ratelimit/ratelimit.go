@@ -0,0 +1,86 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Limiter 限制每分钟的次数,调用均匀分布,不允许突发
+type Limiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// New 创建每分钟最多perMinute次的限制,perMinute不大于0时返回nil,表示不限制
+func New(perMinute int) *Limiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	return &Limiter{interval: time.Minute / time.Duration(perMinute)}
+}
+
+// Interval 返回两次调用之间的最小间隔,不限制时为0
+func (l *Limiter) Interval() time.Duration {
+	if l == nil {
+		return 0
+	}
+	return l.interval
+}
+
+// Wait 阻塞到允许下一次调用,可以在多个goroutine中同时使用
+func (l *Limiter) Wait() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// Group 按key分别限制,如每个机器人各自每分钟最多perMinute次
+type Group struct {
+	mu        sync.Mutex
+	perMinute int
+	limiters  map[string]*Limiter
+}
+
+// NewGroup 创建按key分别限制的Group,perMinute不大于0时返回nil,表示不限制
+func NewGroup(perMinute int) *Group {
+	if perMinute <= 0 {
+		return nil
+	}
+	return &Group{perMinute: perMinute, limiters: make(map[string]*Limiter)}
+}
+
+// Interval 返回同一个key两次调用之间的最小间隔,不限制时为0
+func (g *Group) Interval() time.Duration {
+	if g == nil {
+		return 0
+	}
+	return time.Minute / time.Duration(g.perMinute)
+}
+
+// Wait 阻塞到key允许下一次调用
+func (g *Group) Wait(key string) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	limiter, ok := g.limiters[key]
+	if !ok {
+		limiter = New(g.perMinute)
+		g.limiters[key] = limiter
+	}
+	g.mu.Unlock()
+	limiter.Wait()
+}
@@ -0,0 +1,112 @@
+package ratelimit
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNil(t *testing.T) {
+	tests := []int{0, -1}
+	for _, perMinute := range tests {
+		l, g := New(perMinute), NewGroup(perMinute)
+		if l != nil || g != nil {
+			t.Errorf("New(%d) should return nil", perMinute)
+		}
+		// nil表示不限制,Wait立即返回
+		start := time.Now()
+		l.Wait()
+		g.Wait("a")
+		if l.Interval() != 0 || g.Interval() != 0 || time.Since(start) > 10*time.Millisecond {
+			t.Errorf("nil limiter should not wait")
+		}
+	}
+}
+
+func TestInterval(t *testing.T) {
+	tests := []struct {
+		perMinute int
+		want      time.Duration
+	}{
+		{1, time.Minute},
+		{60, time.Second},
+		{120, 500 * time.Millisecond},
+		{7, time.Minute / 7},
+	}
+	for _, tt := range tests {
+		if got := New(tt.perMinute).Interval(); got != tt.want {
+			t.Errorf("New(%d).Interval() = %s, want %s", tt.perMinute, got, tt.want)
+		}
+		if got := NewGroup(tt.perMinute).Interval(); got != tt.want {
+			t.Errorf("NewGroup(%d).Interval() = %s, want %s", tt.perMinute, got, tt.want)
+		}
+	}
+}
+
+// 1200次/分钟,间隔50ms
+const perMinute = 1200
+
+func TestWait(t *testing.T) {
+	l := New(perMinute)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		l.Wait()
+	}
+	// 第一次不等待,之后每次间隔50ms
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("5 calls took %s, want at least 200ms", elapsed)
+	}
+}
+
+func TestWaitConcurrent(t *testing.T) {
+	l := New(perMinute)
+	var mu sync.Mutex
+	var times []time.Time
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.Wait()
+			mu.Lock()
+			times = append(times, time.Now())
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	// 同时调用时也均匀分布,不允许突发
+	first, last := times[0], times[0]
+	for _, at := range times {
+		if at.Before(first) {
+			first = at
+		}
+		if at.After(last) {
+			last = at
+		}
+	}
+	if spread := last.Sub(first); spread < 190*time.Millisecond {
+		t.Errorf("concurrent calls spread over %s, want at least 200ms", spread)
+	}
+}
+
+func TestGroup(t *testing.T) {
+	g := NewGroup(perMinute)
+
+	// 不同的key互不影响
+	start := time.Now()
+	for _, key := range []string{"a", "b", "c", "d"} {
+		g.Wait(key)
+	}
+	if elapsed := time.Since(start); elapsed > 40*time.Millisecond {
+		t.Errorf("first call for 4 keys took %s, want no wait", elapsed)
+	}
+
+	// 同一个key按间隔等待
+	start = time.Now()
+	g.Wait("a")
+	g.Wait("a")
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("2 more calls for one key took %s, want at least 100ms", elapsed)
+	}
+}
@@ -0,0 +1,151 @@
+package setexpr
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Resolver 将操作数(如列表名、api:groups)解析为ID列表
+type Resolver func(operand string) ([]int64, error)
+
+// 运算符,必须用空格与操作数分开,因为列表名中可能含有 -
+const (
+	OpUnion        = "+" // 并集
+	OpIntersection = "&" // 交集
+	OpDifference   = "-" // 差集
+)
+
+// Eval 计算列表表达式,如 "api:groups - save:上周活动 & vip"
+// 运算从左到右进行,可以用括号改变顺序。结果按首次出现的顺序排列且不重复
+func Eval(expr string, resolve Resolver) ([]int64, error) {
+	p := &parser{tokens: tokenize(expr), resolve: resolve}
+	if len(p.tokens) == 0 {
+		return nil, fmt.Errorf("empty list expression")
+	}
+	result, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in list expression", p.tokens[p.pos])
+	}
+	return result, nil
+}
+
+// tokenize 按空白分割,并拆出紧贴在操作数两侧的括号
+func tokenize(expr string) []string {
+	var tokens []string
+	for _, field := range strings.Fields(expr) {
+		for strings.HasPrefix(field, "(") {
+			tokens = append(tokens, "(")
+			field = field[1:]
+		}
+		closing := 0
+		for strings.HasSuffix(field, ")") {
+			closing++
+			field = field[:len(field)-1]
+		}
+		if field != "" {
+			tokens = append(tokens, field)
+		}
+		for i := 0; i < closing; i++ {
+			tokens = append(tokens, ")")
+		}
+	}
+	return tokens
+}
+
+type parser struct {
+	tokens  []string
+	pos     int
+	resolve Resolver
+}
+
+func (p *parser) parseExpr() ([]int64, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	for p.pos < len(p.tokens) {
+		op := p.tokens[p.pos]
+		if op != OpUnion && op != OpIntersection && op != OpDifference {
+			break
+		}
+		p.pos++
+		right, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		left = apply(op, left, right)
+	}
+	return left, nil
+}
+
+func (p *parser) parseTerm() ([]int64, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("list expression ends unexpectedly")
+	}
+	token := p.tokens[p.pos]
+	p.pos++
+
+	switch token {
+	case "(":
+		result, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if p.pos >= len(p.tokens) || p.tokens[p.pos] != ")" {
+			return nil, fmt.Errorf("missing ) in list expression")
+		}
+		p.pos++
+		return result, nil
+	case ")", OpUnion, OpIntersection, OpDifference:
+		return nil, fmt.Errorf("unexpected %q in list expression", token)
+	}
+
+	ids, err := p.resolve(token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", token, err)
+	}
+	return dedupe(ids), nil
+}
+
+func apply(op string, left, right []int64) []int64 {
+	inRight := make(map[int64]bool, len(right))
+	for _, id := range right {
+		inRight[id] = true
+	}
+
+	var result []int64
+	switch op {
+	case OpUnion:
+		result = append(result, left...)
+		result = append(result, right...)
+		return dedupe(result)
+	case OpIntersection:
+		for _, id := range left {
+			if inRight[id] {
+				result = append(result, id)
+			}
+		}
+	case OpDifference:
+		for _, id := range left {
+			if !inRight[id] {
+				result = append(result, id)
+			}
+		}
+	}
+	return result
+}
+
+func dedupe(ids []int64) []int64 {
+	seen := make(map[int64]bool, len(ids))
+	result := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			result = append(result, id)
+		}
+	}
+	return result
+}
@@ -0,0 +1,90 @@
+package setexpr
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+var lists = map[string][]int64{
+	"a":     {1, 2, 3, 4},
+	"b":     {3, 4, 5},
+	"c":     {4, 6},
+	"dup":   {7, 7, 8},
+	"empty": {},
+	"a-b":   {42},
+}
+
+func resolve(operand string) ([]int64, error) {
+	ids, ok := lists[operand]
+	if !ok {
+		return nil, fmt.Errorf("list %s not found", operand)
+	}
+	return ids, nil
+}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		expr string
+		want []int64
+	}{
+		{"a", []int64{1, 2, 3, 4}},
+		{"a + b", []int64{1, 2, 3, 4, 5}},
+		{"b + a", []int64{3, 4, 5, 1, 2}},
+		{"a & b", []int64{3, 4}},
+		{"a - b", []int64{1, 2}},
+		{"b - a", []int64{5}},
+		// 从左到右计算
+		{"a - b & c", []int64{}},
+		{"a + b - c", []int64{1, 2, 3, 5}},
+		{"a & b + c", []int64{3, 4, 6}},
+		// 括号改变顺序
+		{"a - (b & c)", []int64{1, 2, 3}},
+		{"(a - b) + c", []int64{1, 2, 4, 6}},
+		{"((a))", []int64{1, 2, 3, 4}},
+		{"a - ( b & c )", []int64{1, 2, 3}},
+		// 结果不重复
+		{"dup", []int64{7, 8}},
+		{"dup + dup", []int64{7, 8}},
+		{"a & empty", []int64{}},
+		{"empty + b", []int64{3, 4, 5}},
+		// 运算符两侧没有空格时是列表名的一部分
+		{"a-b", []int64{42}},
+		{"  a   +\tb  ", []int64{1, 2, 3, 4, 5}},
+	}
+	for _, tt := range tests {
+		got, err := Eval(tt.expr, resolve)
+		if err != nil {
+			t.Errorf("Eval(%q): %v", tt.expr, err)
+			continue
+		}
+		if len(got) == 0 && len(tt.want) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Eval(%q) = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestEvalInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		"   ",
+		"a +",
+		"+ a",
+		"a b",
+		"a & & b",
+		"(a + b",
+		"a + b)",
+		"()",
+		"missing",
+		"a - missing",
+		"a+b",
+	}
+	for _, expr := range tests {
+		if got, err := Eval(expr, resolve); err == nil {
+			t.Errorf("Eval(%q) = %v, want error", expr, got)
+		}
+	}
+}
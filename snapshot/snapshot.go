@@ -0,0 +1,252 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 快照保存目录,按机器人分子目录: snapshots/<机器人QQ>/groups-<unix>.json
+const snapshotDir = "snapshots"
+
+// 快照类型
+const (
+	KindGroups  = "groups"
+	KindFriends = "friends"
+)
+
+// 引用快照时可用的特殊名称
+const (
+	RefLatest = "latest" // 最新的快照
+	RefPrev   = "prev"   // 最新快照的前一个
+)
+
+var ErrNoSnapshot = errors.New("no snapshot found")
+
+// Target 快照中的一个群或好友
+type Target struct {
+	ID   int64           `json:"id"`
+	Name string          `json:"name"`
+	Data json.RawMessage `json:"data,omitempty"` // API返回的原始资料
+}
+
+// Snapshot 某个时刻机器人的群列表或好友列表
+type Snapshot struct {
+	Bot     string   `json:"bot"`
+	BotName string   `json:"bot_name,omitempty"`
+	Kind    string   `json:"kind"`
+	Time    int64    `json:"time"`
+	API     string   `json:"api,omitempty"`
+	Count   int      `json:"count"`
+	Targets []Target `json:"targets"`
+}
+
+// Info 快照的概要,用于列出快照而不读取全部内容
+type Info struct {
+	Bot  string
+	Kind string
+	Time int64
+	Path string
+}
+
+func botDir(bot string) string {
+	return filepath.Join(snapshotDir, bot)
+}
+
+// Save 保存快照,目标按ID排序。与最新快照的目标完全相同时不重复保存,返回false
+func Save(s *Snapshot) (string, bool, error) {
+	if s.Bot == "" {
+		return "", false, fmt.Errorf("snapshot bot is empty")
+	}
+	sort.Slice(s.Targets, func(i, j int) bool { return s.Targets[i].ID < s.Targets[j].ID })
+	s.Count = len(s.Targets)
+	if s.Time == 0 {
+		s.Time = time.Now().Unix()
+	}
+
+	if latest, err := Load(s.Bot, s.Kind, RefLatest); err == nil && sameTargets(latest, s) {
+		return latest.path(), false, nil
+	}
+
+	if err := os.MkdirAll(botDir(s.Bot), 0755); err != nil {
+		return "", false, err
+	}
+	data, err := json.MarshalIndent(s, "", "    ")
+	if err != nil {
+		return "", false, err
+	}
+	path := s.path()
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", false, err
+	}
+	return path, true, nil
+}
+
+func (s *Snapshot) path() string {
+	return filepath.Join(botDir(s.Bot), fmt.Sprintf("%s-%d.json", s.Kind, s.Time))
+}
+
+func sameTargets(a, b *Snapshot) bool {
+	if len(a.Targets) != len(b.Targets) {
+		return false
+	}
+	for i := range a.Targets {
+		if a.Targets[i].ID != b.Targets[i].ID {
+			return false
+		}
+	}
+	return true
+}
+
+// List 按时间顺序列出机器人某类快照
+func List(bot string, kind string) ([]Info, error) {
+	entries, err := os.ReadDir(botDir(bot))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var infos []Info
+	prefix := kind + "-"
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		unix, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".json"), 10, 64)
+		if err != nil {
+			continue
+		}
+		infos = append(infos, Info{Bot: bot, Kind: kind, Time: unix, Path: filepath.Join(botDir(bot), name)})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Time < infos[j].Time })
+	return infos, nil
+}
+
+// Find 按引用查找快照。ref可以是latest、prev、unix时间戳,
+// 或者日期时间(2006-01-02、2006-01-02T15:04、2006-01-02 15:04),此时返回该时间点或之前最近的快照
+func Find(bot string, kind string, ref string) (Info, error) {
+	infos, err := List(bot, kind)
+	if err != nil {
+		return Info{}, err
+	}
+	if len(infos) == 0 {
+		return Info{}, fmt.Errorf("%w for bot %s (%s)", ErrNoSnapshot, bot, kind)
+	}
+
+	switch ref {
+	case RefLatest, "":
+		return infos[len(infos)-1], nil
+	case RefPrev:
+		if len(infos) < 2 {
+			return Info{}, fmt.Errorf("%w before the latest one for bot %s (%s)", ErrNoSnapshot, bot, kind)
+		}
+		return infos[len(infos)-2], nil
+	}
+
+	at, err := ParseTime(ref)
+	if err != nil {
+		return Info{}, err
+	}
+	return FindAt(infos, at)
+}
+
+// FindAt 返回at时刻或之前最近的快照
+func FindAt(infos []Info, at time.Time) (Info, error) {
+	for i := len(infos) - 1; i >= 0; i-- {
+		if infos[i].Time <= at.Unix() {
+			return infos[i], nil
+		}
+	}
+	return Info{}, fmt.Errorf("%w at or before %s", ErrNoSnapshot, at.Format("2006-01-02 15:04:05"))
+}
+
+// ParseTime 解析快照引用中的时间,支持unix时间戳和本地日期时间
+func ParseTime(ref string) (time.Time, error) {
+	if unix, err := strconv.ParseInt(ref, 10, 64); err == nil {
+		return time.Unix(unix, 0), nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, ref, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid snapshot reference %q", ref)
+}
+
+// Load 读取引用对应的快照
+func Load(bot string, kind string, ref string) (*Snapshot, error) {
+	info, err := Find(bot, kind, ref)
+	if err != nil {
+		return nil, err
+	}
+	return Read(info.Path)
+}
+
+// Read 读取快照文件
+func Read(path string) (*Snapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s Snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot %s: %w", path, err)
+	}
+	return &s, nil
+}
+
+// Diff 比较两个快照,返回新加入和已退出的目标
+func Diff(from, to *Snapshot) (joined []Target, left []Target) {
+	before := make(map[int64]bool, len(from.Targets))
+	for _, target := range from.Targets {
+		before[target.ID] = true
+	}
+	after := make(map[int64]bool, len(to.Targets))
+	for _, target := range to.Targets {
+		after[target.ID] = true
+		if !before[target.ID] {
+			joined = append(joined, target)
+		}
+	}
+	for _, target := range from.Targets {
+		if !after[target.ID] {
+			left = append(left, target)
+		}
+	}
+	return joined, left
+}
+
+// IDs 返回目标的ID列表
+func IDs(targets []Target) []int64 {
+	ids := make([]int64, 0, len(targets))
+	for _, target := range targets {
+		ids = append(ids, target.ID)
+	}
+	return ids
+}
+
+// Bots 列出已保存快照的机器人
+func Bots() ([]string, error) {
+	entries, err := os.ReadDir(snapshotDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var bots []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			bots = append(bots, entry.Name())
+		}
+	}
+	return bots, nil
+}
@@ -0,0 +1,158 @@
+package snapshot
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func targets(ids ...int64) []Target {
+	list := make([]Target, 0, len(ids))
+	for _, id := range ids {
+		list = append(list, Target{ID: id})
+	}
+	return list
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		from, to []int64
+		joined   []int64
+		left     []int64
+	}{
+		{[]int64{1, 2, 3}, []int64{1, 2, 3}, nil, nil},
+		{[]int64{1, 2, 3}, []int64{2, 3, 4, 5}, []int64{4, 5}, []int64{1}},
+		{nil, []int64{1, 2}, []int64{1, 2}, nil},
+		{[]int64{1, 2}, nil, nil, []int64{1, 2}},
+		{nil, nil, nil, nil},
+		// 顺序与快照中一致
+		{[]int64{3, 1, 2}, []int64{5, 2, 4}, []int64{5, 4}, []int64{3, 1}},
+	}
+	for _, tt := range tests {
+		joined, left := Diff(&Snapshot{Targets: targets(tt.from...)}, &Snapshot{Targets: targets(tt.to...)})
+		if got := IDs(joined); !equalIDs(got, tt.joined) {
+			t.Errorf("Diff(%v, %v) joined = %v, want %v", tt.from, tt.to, got, tt.joined)
+		}
+		if got := IDs(left); !equalIDs(got, tt.left) {
+			t.Errorf("Diff(%v, %v) left = %v, want %v", tt.from, tt.to, got, tt.left)
+		}
+	}
+}
+
+func equalIDs(a, b []int64) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func TestParseTime(t *testing.T) {
+	tests := []struct {
+		ref  string
+		want time.Time
+	}{
+		{"1760000000", time.Unix(1760000000, 0)},
+		{"2026-10-16", time.Date(2026, 10, 16, 0, 0, 0, 0, time.Local)},
+		{"2026-10-16 08:30", time.Date(2026, 10, 16, 8, 30, 0, 0, time.Local)},
+		{"2026-10-16T08:30", time.Date(2026, 10, 16, 8, 30, 0, 0, time.Local)},
+		{"2026-10-16 08:30:15", time.Date(2026, 10, 16, 8, 30, 15, 0, time.Local)},
+	}
+	for _, tt := range tests {
+		got, err := ParseTime(tt.ref)
+		if err != nil {
+			t.Errorf("ParseTime(%q): %v", tt.ref, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("ParseTime(%q) = %v, want %v", tt.ref, got, tt.want)
+		}
+	}
+	for _, ref := range []string{"yesterday", "2026/10/16", "16-10-2026"} {
+		if _, err := ParseTime(ref); err == nil {
+			t.Errorf("ParseTime(%q) succeeded, want error", ref)
+		}
+	}
+}
+
+func TestFind(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	if _, err := Find("10001", KindGroups, RefLatest); !errors.Is(err, ErrNoSnapshot) {
+		t.Fatalf("Find without snapshots = %v, want ErrNoSnapshot", err)
+	}
+
+	for _, tt := range []struct {
+		s         *Snapshot
+		wantSaved bool
+	}{
+		{&Snapshot{Bot: "10001", Kind: KindGroups, Time: 100, Targets: targets(2, 1)}, true},
+		// 目标相同时不重复保存
+		{&Snapshot{Bot: "10001", Kind: KindGroups, Time: 150, Targets: targets(1, 2)}, false},
+		{&Snapshot{Bot: "10001", Kind: KindGroups, Time: 200, Targets: targets(1, 3)}, true},
+		{&Snapshot{Bot: "10001", Kind: KindFriends, Time: 300, Targets: targets(9)}, true},
+	} {
+		_, saved, err := Save(tt.s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if saved != tt.wantSaved {
+			t.Errorf("Save(%s at %d) saved = %v, want %v", tt.s.Kind, tt.s.Time, saved, tt.wantSaved)
+		}
+	}
+
+	infos, err := List("10001", KindGroups)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 2 || infos[0].Time != 100 || infos[1].Time != 200 {
+		t.Fatalf("List = %+v, want snapshots at 100 and 200", infos)
+	}
+
+	tests := []struct {
+		ref  string
+		want int64
+	}{
+		{RefLatest, 200},
+		{"", 200},
+		{RefPrev, 100},
+		{"100", 100},
+		{"199", 100},
+		{"1000", 200},
+	}
+	for _, tt := range tests {
+		info, err := Find("10001", KindGroups, tt.ref)
+		if err != nil {
+			t.Errorf("Find(%q): %v", tt.ref, err)
+			continue
+		}
+		if info.Time != tt.want {
+			t.Errorf("Find(%q) = %d, want %d", tt.ref, info.Time, tt.want)
+		}
+	}
+	if _, err := Find("10001", KindGroups, "99"); !errors.Is(err, ErrNoSnapshot) {
+		t.Errorf("Find before the first snapshot = %v, want ErrNoSnapshot", err)
+	}
+	if _, err := Find("10001", KindFriends, RefPrev); !errors.Is(err, ErrNoSnapshot) {
+		t.Errorf("Find prev with one snapshot = %v, want ErrNoSnapshot", err)
+	}
+
+	s, err := Load("10001", KindGroups, RefLatest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Count != 2 || !reflect.DeepEqual(IDs(s.Targets), []int64{1, 3}) {
+		t.Errorf("Load = %+v, want targets [1 3]", s)
+	}
+	if bots, err := Bots(); err != nil || !reflect.DeepEqual(bots, []string{"10001"}) {
+		t.Errorf("Bots = %v, %v, want [10001]", bots, err)
+	}
+}
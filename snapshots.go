@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hoshinonyaruko/gensokyo-broadcast/snapshot"
+	"github.com/hoshinonyaruko/gensokyo-broadcast/txt"
+)
+
+// 列表表达式中按快照差异选择目标的操作数前缀
+const (
+	operandJoined        = "joined:"         // 自某个快照以来新加入的群
+	operandLeft          = "left:"           // 自某个快照以来已退出的群
+	operandJoinedFriends = "joined-friends:" // 自某个快照以来新增的好友
+	operandLeftFriends   = "left-friends:"   // 自某个快照以来删除的好友
+)
+
+type botInfo struct {
+	ID   string
+	Name string
+}
+
+// botInfos 缓存每个API地址对应的机器人,避免每次快照都调用get_login_info
+var (
+	botInfosMu sync.Mutex
+	botInfos   = make(map[string]botInfo)
+)
+
+// fetchBotInfo 通过get_login_info获取机器人的QQ号和昵称
+func fetchBotInfo(apiURL string, token string) (botInfo, error) {
+	botInfosMu.Lock()
+	info, ok := botInfos[apiURL]
+	botInfosMu.Unlock()
+	if ok {
+		return info, nil
+	}
+	responseContent, err := postAction(apiURL, "get_login_info", map[string]interface{}{}, token)
+	if err != nil {
+		return botInfo{}, err
+	}
+	var response struct {
+		Data struct {
+			UserID   json.Number `json:"user_id"`
+			Nickname string      `json:"nickname"`
+		} `json:"data"`
+	}
+	decoder := json.NewDecoder(strings.NewReader(responseContent))
+	decoder.UseNumber()
+	if err := decoder.Decode(&response); err != nil {
+		return botInfo{}, fmt.Errorf("failed to parse login info: %w", err)
+	}
+	if response.Data.UserID == "" {
+		return botInfo{}, fmt.Errorf("login info has no user_id: %s", responseContent)
+	}
+	info = botInfo{ID: response.Data.UserID.String(), Name: response.Data.Nickname}
+	botInfosMu.Lock()
+	botInfos[apiURL] = info
+	botInfosMu.Unlock()
+	return info, nil
+}
+
+// groupTargets 将群列表转换为快照目标
+func groupTargets(groups []Group) []snapshot.Target {
+	targets := make([]snapshot.Target, 0, len(groups))
+	for _, group := range groups {
+		data, _ := json.Marshal(group)
+		targets = append(targets, snapshot.Target{ID: group.GroupID, Name: group.GroupName, Data: data})
+	}
+	return targets
+}
+
+// friendTargets 将好友列表转换为快照目标
+func friendTargets(friends []FriendData) []snapshot.Target {
+	targets := make([]snapshot.Target, 0, len(friends))
+	for _, friend := range friends {
+		friendID, err := strconv.ParseInt(friend.UserID, 10, 64)
+		if err != nil {
+			continue
+		}
+		data, _ := json.Marshal(friend)
+		targets = append(targets, snapshot.Target{ID: friendID, Name: friend.Nickname, Data: data})
+	}
+	return targets
+}
+
+// saveGroupSnapshot 保存群列表快照,失败时只记录日志,不影响发送
+func saveGroupSnapshot(apiURL string, token string, groups []Group) {
+	saveSnapshot(apiURL, token, snapshot.KindGroups, groupTargets(groups))
+}
+
+// saveFriendSnapshot 保存好友列表快照
+func saveFriendSnapshot(apiURL string, token string, friends []FriendData) {
+	saveSnapshot(apiURL, token, snapshot.KindFriends, friendTargets(friends))
+}
+
+func saveSnapshot(apiURL string, token string, kind string, targets []snapshot.Target) {
+	if dryRun {
+		return
+	}
+	bot, err := fetchBotInfo(apiURL, token)
+	if err != nil {
+		log.Printf("Failed to save %s snapshot: %v", kind, err)
+		return
+	}
+	path, saved, err := snapshot.Save(&snapshot.Snapshot{
+		Bot:     bot.ID,
+		BotName: bot.Name,
+		Kind:    kind,
+		API:     apiURL,
+		Targets: targets,
+	})
+	if err != nil {
+		log.Printf("Failed to save %s snapshot: %v", kind, err)
+		return
+	}
+	if saved {
+		log.Printf("Saved %s snapshot of bot %s to %s", kind, bot.ID, path)
+	}
+}
+
+// resolveSnapshotRef 将快照引用解析为快照,save:存档名 表示该任务第一次发送时的快照
+func resolveSnapshotRef(ts *txt.TxtStore, bot string, kind string, ref string) (*snapshot.Snapshot, error) {
+	if strings.HasPrefix(ref, operandSave) {
+		start, err := saveStartTime(ts, strings.TrimPrefix(ref, operandSave))
+		if err != nil {
+			return nil, err
+		}
+		infos, err := snapshot.List(bot, kind)
+		if err != nil {
+			return nil, err
+		}
+		info, err := snapshot.FindAt(infos, start)
+		if err != nil {
+			return nil, err
+		}
+		return snapshot.Read(info.Path)
+	}
+	return snapshot.Load(bot, kind, ref)
+}
+
+// saveStartTime 返回存档中最早的发送时间
+func saveStartTime(ts *txt.TxtStore, saveName string) (time.Time, error) {
+	lines, err := ts.GetFileContent(saveName + "-save")
+	if err != nil {
+		return time.Time{}, err
+	}
+	var start time.Time
+	for _, line := range lines {
+		if _, sent := parseSaveLine(line); !sent {
+			continue
+		}
+		sentAt, err := time.ParseInLocation(timeLayout, sendDateRegex.FindString(strings.TrimSpace(line)), time.Local)
+		if err != nil {
+			continue
+		}
+		if start.IsZero() || sentAt.Before(start) {
+			start = sentAt
+		}
+	}
+	if start.IsZero() {
+		return time.Time{}, fmt.Errorf("save %s has no send record", saveName)
+	}
+	return start, nil
+}
+
+// resolveSnapshotOperand 解析 joined:引用 等操作数,与实时获取的列表比较
+func resolveSnapshotOperand(ts *txt.TxtStore, apiURL string, token string, operand string) ([]int64, error) {
+	kind := snapshot.KindGroups
+	if strings.HasPrefix(operand, operandJoinedFriends) || strings.HasPrefix(operand, operandLeftFriends) {
+		kind = snapshot.KindFriends
+	}
+	joined := strings.HasPrefix(operand, operandJoined) || strings.HasPrefix(operand, operandJoinedFriends)
+	ref := operand[strings.Index(operand, ":")+1:]
+
+	bot, err := fetchBotInfo(apiURL, token)
+	if err != nil {
+		return nil, err
+	}
+	// 先确定参照快照,再获取最新列表,否则 latest 会指向本次获取的快照
+	from, err := resolveSnapshotRef(ts, bot.ID, kind, ref)
+	if err != nil {
+		return nil, err
+	}
+	to := &snapshot.Snapshot{}
+	if kind == snapshot.KindFriends {
+		friendList, err := fetchFriendList(apiURL, token, false)
+		if err != nil {
+			return nil, err
+		}
+		recordFriendNames(friendList.Data)
+		to.Targets = friendTargets(friendList.Data)
+	} else {
+		groupList, err := fetchGroupList(apiURL, token, false)
+		if err != nil {
+			return nil, err
+		}
+		recordGroupNames(groupList.Data)
+		to.Targets = groupTargets(groupList.Data)
+	}
+
+	joinedTargets, leftTargets := snapshot.Diff(from, to)
+	if joined {
+		return snapshot.IDs(joinedTargets), nil
+	}
+	return snapshot.IDs(leftTargets), nil
+}
+
+// isSnapshotOperand 判断操作数是否为快照差异
+func isSnapshotOperand(operand string) bool {
+	for _, prefix := range []string{operandJoined, operandLeft, operandJoinedFriends, operandLeftFriends} {
+		if strings.HasPrefix(operand, prefix) {
+			return true
+		}
+	}
+	return false
+}
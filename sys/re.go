@@ -12,6 +12,7 @@ import (
 	"runtime"
 	"strings"
 
+	"github.com/hoshinonyaruko/gensokyo-broadcast/httpclient"
 	"golang.org/x/net/html"
 )
 
@@ -70,7 +71,7 @@ func setConsoleTitle(title string) error {
 
 func GetLatestTag(repo string) (string, error) {
 	url := fmt.Sprintf("https://gitee.com/api/v5/repos/%s/tags", repo)
-	resp, err := http.Get(url)
+	resp, err := httpclient.Get(url, "")
 	if err != nil {
 		return "", err
 	}
@@ -128,7 +129,7 @@ func RestartApplication() {
 
 func GetPublicIP() (string, error) {
 	// 访问iframe提供的URL
-	resp, err := http.Get("http://only-985281-116-238-216-144.nstool.yqkk.link/")
+	resp, err := httpclient.Get("http://only-985281-116-238-216-144.nstool.yqkk.link/", "")
 	if err != nil {
 		return "", err
 	}
@@ -168,7 +169,7 @@ func findIP(node *html.Node) (string, bool) {
 		for _, a := range node.Attr {
 			if a.Key == "src" {
 				// We found the iframe, now let's send a request to the src URL
-				resp, err := http.Get(a.Val)
+				resp, err := httpclient.Get(a.Val, "")
 				if err != nil {
 					return "", false
 				}
@@ -0,0 +1,54 @@
+package tlscert
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// ACME申请到的证书和账号密钥的保存目录
+const acmeCacheDir = "acme-cache"
+
+// NewACMEManager 创建从ACME服务(如内网的step-ca)自动申请和续期证书的autocert.Manager。
+// 只为hosts中的域名申请证书,IP会被忽略;caFile不为空时访问ACME服务只信任该CA
+func NewACMEManager(directory, email, caFile string, hosts []string) (*autocert.Manager, error) {
+	var domains []string
+	for _, host := range hosts {
+		if net.ParseIP(host) == nil {
+			domains = append(domains, host)
+		}
+	}
+	if len(domains) == 0 {
+		return nil, errors.New("ACME needs at least one domain name in tlsHosts")
+	}
+
+	client := &acme.Client{DirectoryURL: directory}
+	if caFile != "" {
+		pemData, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pemData) {
+			return nil, fmt.Errorf("no certificate found in %s", caFile)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+		client.HTTPClient = &http.Client{Transport: transport}
+	}
+
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(acmeCacheDir),
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Client:     client,
+		Email:      email,
+	}, nil
+}
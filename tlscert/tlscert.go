@@ -0,0 +1,223 @@
+package tlscert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// 自动生成的自签名证书的有效期
+const selfSignedValidity = 3 * 365 * 24 * time.Hour
+
+// 自动生成的证书的组织名称
+const organization = "gensokyo-broadcast"
+
+// GenerateSelfSigned 生成包含hosts(域名或IP)的自签名证书,写入certFile和keyFile
+func GenerateSelfSigned(certFile, keyFile string, hosts []string) error {
+	if len(hosts) == 0 {
+		return errors.New("self-signed certificate needs at least one host")
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{organization}, CommonName: hosts[0]},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	// 先写私钥,私钥只允许当前用户读取
+	if err := writePEM(keyFile, "PRIVATE KEY", keyDer, 0600); err != nil {
+		return err
+	}
+	return writePEM(certFile, "CERTIFICATE", der, 0644)
+}
+
+// generated 判断证书是否由GenerateSelfSigned生成
+func generated(leaf *x509.Certificate) bool {
+	return len(leaf.Subject.Organization) == 1 && leaf.Subject.Organization[0] == organization && leaf.Issuer.CommonName == leaf.Subject.CommonName
+}
+
+func writePEM(filename, blockType string, der []byte, perm os.FileMode) error {
+	tmp := filename + ".tmp"
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
+
+// sameHosts 判断证书包含的域名和IP是否与hosts相同,不区分顺序
+func sameHosts(leaf *x509.Certificate, hosts []string) bool {
+	want := make(map[string]bool, len(hosts))
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			want[ip.String()] = true
+		} else {
+			want[host] = true
+		}
+	}
+	got := make(map[string]bool, len(leaf.DNSNames)+len(leaf.IPAddresses))
+	for _, name := range leaf.DNSNames {
+		got[name] = true
+	}
+	for _, ip := range leaf.IPAddresses {
+		got[ip.String()] = true
+	}
+	if len(got) != len(want) {
+		return false
+	}
+	for host := range want {
+		if !got[host] {
+			return false
+		}
+	}
+	return true
+}
+
+// EnsureSelfSigned 证书或私钥不存在、证书已过期,或自动生成的证书包含的域名和IP与hosts不同时
+// 重新生成自签名证书,返回是否生成了新证书。用户放在同一位置的其他证书只在过期时替换
+func EnsureSelfSigned(certFile, keyFile string, hosts []string) (bool, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err == nil {
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err == nil && time.Now().Before(leaf.NotAfter) && (!generated(leaf) || sameHosts(leaf, hosts)) {
+			return false, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return false, err
+	}
+	if err := GenerateSelfSigned(certFile, keyFile, hosts); err != nil {
+		return false, fmt.Errorf("failed to generate self-signed certificate: %w", err)
+	}
+	return true, nil
+}
+
+// Reloader 保存当前使用的证书,Reload时从文件重新读取,读取失败时继续使用旧证书
+type Reloader struct {
+	certFile, keyFile string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+// NewReloader 读取证书和私钥,文件无效时返回错误
+func NewReloader(certFile, keyFile string) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload 从文件重新读取证书和私钥,新的连接使用新证书
+func (r *Reloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.cert = &cert
+	r.mu.Unlock()
+	return nil
+}
+
+// GetCertificate 用于tls.Config.GetCertificate
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// 证书和私钥通常一前一后写入,等文件不再变化后再通知
+const watchDelay = time.Second
+
+// WatchFiles 监听证书和私钥文件,修改、替换后调用changed。
+// 监听所在的目录,这样先写临时文件再改名替换的方式也能发现。Windows上没有SIGHUP,靠它重新读取证书
+func WatchFiles(files []string, changed func()) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	watched := make(map[string]bool)
+	for _, file := range files {
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			watcher.Close()
+			return err
+		}
+		watched[abs] = true
+		if err := watcher.Add(filepath.Dir(abs)); err != nil {
+			watcher.Close()
+			return err
+		}
+	}
+
+	go func() {
+		defer watcher.Close()
+		var timer *time.Timer
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if !watched[filepath.Clean(event.Name)] || event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+					continue
+				}
+				if timer != nil {
+					timer.Stop()
+				}
+				timer = time.AfterFunc(watchDelay, changed)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Println("Certificate watcher error:", err)
+			}
+		}
+	}()
+	return nil
+}
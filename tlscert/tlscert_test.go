@@ -0,0 +1,104 @@
+package tlscert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// certHosts 返回证书包含的域名和IP
+func certHosts(t *testing.T, certFile, keyFile string) []string {
+	t.Helper()
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts := append([]string{}, leaf.DNSNames...)
+	for _, ip := range leaf.IPAddresses {
+		hosts = append(hosts, ip.String())
+	}
+	return hosts
+}
+
+func TestEnsureSelfSigned(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	tests := []struct {
+		hosts     []string
+		generated bool
+		want      []string
+	}{
+		{[]string{"localhost", "::1"}, true, []string{"localhost", "::1"}},
+		// 顺序和IP的写法不同不重新生成
+		{[]string{"::1", "localhost"}, false, []string{"localhost", "::1"}},
+		{[]string{"localhost", "0:0:0:0:0:0:0:1"}, false, []string{"localhost", "::1"}},
+		// 修改tlsHosts后重新生成
+		{[]string{"localhost", "::1", "bd.lan"}, true, []string{"localhost", "bd.lan", "::1"}},
+		{[]string{"bd.lan"}, true, []string{"bd.lan"}},
+	}
+	for _, tt := range tests {
+		generated, err := EnsureSelfSigned(certFile, keyFile, tt.hosts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if generated != tt.generated {
+			t.Errorf("EnsureSelfSigned(%v) generated = %v, want %v", tt.hosts, generated, tt.generated)
+		}
+		if got := certHosts(t, certFile, keyFile); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("EnsureSelfSigned(%v) hosts = %v, want %v", tt.hosts, got, tt.want)
+		}
+	}
+}
+
+func TestEnsureSelfSignedKeepsOtherCert(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	// 用户放在默认位置的其他证书,域名与tlsHosts不同时也不替换
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{Organization: []string{"lan"}, CommonName: "bd.lan"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"bd.lan"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writePEM(keyFile, "PRIVATE KEY", keyDer, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := writePEM(certFile, "CERTIFICATE", der, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	generated, err := EnsureSelfSigned(certFile, keyFile, []string{"localhost"})
+	if err != nil || generated {
+		t.Fatalf("EnsureSelfSigned = %v, %v, want the certificate kept", generated, err)
+	}
+	if got := certHosts(t, certFile, keyFile); !reflect.DeepEqual(got, []string{"bd.lan"}) {
+		t.Errorf("hosts = %v, want [bd.lan]", got)
+	}
+}
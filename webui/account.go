@@ -0,0 +1,144 @@
+package webui
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/boltdb/bolt"
+	"github.com/gin-gonic/gin"
+	"github.com/hoshinonyaruko/gensokyo-broadcast/config"
+)
+
+// account config.json中的登入用户,总是管理员,修改密码后同时更新config.json
+var account struct {
+	sync.RWMutex
+	name       string
+	hash       string
+	mustChange bool
+}
+
+// InitAccount 从配置载入登入用户,使用默认密码时提示首次登入后修改,需要在InitializeDB之后调用
+func InitAccount(cfg config.Config) {
+	initUserBucket()
+	initTokenBucket()
+
+	account.Lock()
+	account.name = cfg.Account
+	account.hash = cfg.PasswordHash
+	account.mustChange = cfg.MustChangePassword
+	account.Unlock()
+
+	if cfg.MustChangePassword {
+		fmt.Printf("首次使用请以用户名[%v] 默认密码[%v] 登入WebUI,登入后需要先修改密码,不包含[],遇到问题可到QQ群:196173384 请教\n", cfg.Account, config.DefaultPassword)
+		fmt.Printf("first run: login with account[%v] default password[%v], you will be asked to change the password, not include []\n", cfg.Account, config.DefaultPassword)
+	}
+}
+
+// builtinAccount 返回config.json中的用户名
+func builtinAccount() string {
+	account.RLock()
+	defer account.RUnlock()
+	return account.name
+}
+
+// checkCredentials 检查用户名和密码,成功时返回用户
+func checkCredentials(username, password string) (User, bool) {
+	user, err := getUser(username)
+	if err != nil {
+		// 用户不存在时也比较一次哈希,避免通过响应时间判断用户名是否存在
+		account.RLock()
+		config.CheckPassword(account.hash, password)
+		account.RUnlock()
+		return User{}, false
+	}
+	return user, config.CheckPassword(user.PasswordHash, password)
+}
+
+// handleChangePassword 处理 /change-password 路由的请求,需要已登入并提供当前密码
+func handleChangePassword(c *gin.Context) {
+	// 使用默认或初始密码时也可以修改
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	if user.Token != "" {
+		abortAuth(c, http.StatusForbidden, ErrCodeForbidden, "Forbidden: API tokens cannot change passwords")
+		return
+	}
+
+	var request struct {
+		OldPassword string `json:"oldPassword" binding:"required"`
+		NewPassword string `json:"newPassword" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !config.CheckPassword(user.PasswordHash, request.OldPassword) {
+		fmt.Printf("用户[%v]修改密码失败: 当前密码错误 来源:%v\n", user.Name, c.ClientIP())
+		auditAction(c, user, "password_change_failed", nil)
+		c.JSON(http.StatusForbidden, gin.H{"error": "Current password is incorrect"})
+		return
+	}
+	if request.NewPassword == request.OldPassword {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "New password must differ from the current password"})
+		return
+	}
+	if err := config.ValidateNewPassword(request.NewPassword); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var err error
+	if user.Builtin {
+		err = changeBuiltinPassword(request.NewPassword)
+	} else {
+		err = changeUserPassword(user.Name, request.NewPassword)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Could not save password: %v", err)})
+		return
+	}
+	fmt.Printf("用户[%v]已修改密码 来源:%v\n", user.Name, c.ClientIP())
+	// config.json中的用户修改密码时会写入config.json
+	auditAction(c, user, "password_change", map[string]interface{}{"config_json": user.Builtin})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed"})
+}
+
+// changeBuiltinPassword 修改config.json中用户的密码
+func changeBuiltinPassword(password string) error {
+	account.Lock()
+	defer account.Unlock()
+	cfg, err := config.SetPassword("", password, false)
+	if err != nil {
+		return err
+	}
+	account.hash = cfg.PasswordHash
+	account.mustChange = false
+	return nil
+}
+
+// changeUserPassword 修改cookie.db中用户的密码
+func changeUserPassword(name, password string) error {
+	hash, err := config.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	return dbcookie.Update(func(tx *bolt.Tx) error {
+		data := tx.Bucket([]byte(UserBucket)).Get([]byte(name))
+		if data == nil {
+			return ErrUserNotFound
+		}
+		var u User
+		if err := json.Unmarshal(data, &u); err != nil {
+			return err
+		}
+		u.PasswordHash = hash
+		u.MustChangePassword = false
+		return putUser(tx, u)
+	})
+}
@@ -4,14 +4,20 @@ import (
 	"embed"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/hoshinonyaruko/gensokyo-broadcast/audit"
 	"github.com/hoshinonyaruko/gensokyo-broadcast/config"
+	"github.com/hoshinonyaruko/gensokyo-broadcast/lockout"
 	"golang.org/x/text/encoding/simplifiedchinese"
 	"golang.org/x/text/transform"
 )
@@ -42,6 +48,16 @@ func CombinedMiddleware(config config.Config) gin.HandlerFunc {
 				HandleLoginRequest(c, config)
 				return
 			}
+			// 处理/api/change-password的POST请求
+			if c.Param("filepath") == "/api/change-password" && c.Request.Method == http.MethodPost {
+				handleChangePassword(c)
+				return
+			}
+			// 处理/api/logout的POST请求
+			if c.Param("filepath") == "/api/logout" && c.Request.Method == http.MethodPost {
+				handleLogout(c, config)
+				return
+			}
 			// 处理/api/check-login-status的GET请求
 			if c.Param("filepath") == "/api/check-login-status" && c.Request.Method == http.MethodGet {
 				HandleCheckLoginStatusRequest(c)
@@ -49,7 +65,7 @@ func CombinedMiddleware(config config.Config) gin.HandlerFunc {
 			}
 			// 处理/api/run的GET请求
 			if c.Param("filepath") == "/api/run" && c.Request.Method == http.MethodGet {
-				handleRunCommand(c)
+				handleRunCommand(c, config)
 				return
 			}
 			// 处理 /api/list-files 路由的请求
@@ -57,6 +73,51 @@ func CombinedMiddleware(config config.Config) gin.HandlerFunc {
 				handleListFiles(c)
 				return
 			}
+			// 处理 /api/preview 路由的请求
+			if c.Param("filepath") == "/api/preview" && c.Request.Method == http.MethodGet {
+				handlePreview(c)
+				return
+			}
+			// 处理 /api/exclusions 路由的请求
+			if c.Param("filepath") == "/api/exclusions" {
+				handleExclusions(c)
+				return
+			}
+			// 处理 /api/list-eval 路由的请求
+			if c.Param("filepath") == "/api/list-eval" && c.Request.Method == http.MethodPost {
+				handleListEval(c)
+				return
+			}
+			// 处理 /api/schedules 路由的请求
+			if c.Param("filepath") == "/api/schedules" {
+				handleSchedules(c, config)
+				return
+			}
+			// 处理 /api/approvals 路由的请求
+			if c.Param("filepath") == "/api/approvals" {
+				handleApprovals(c)
+				return
+			}
+			// 处理 /api/sessions 路由的请求
+			if c.Param("filepath") == "/api/sessions" {
+				handleSessions(c)
+				return
+			}
+			// 处理 /api/tokens 路由的请求
+			if c.Param("filepath") == "/api/tokens" {
+				handleTokens(c)
+				return
+			}
+			// 处理 /api/users 路由的请求
+			if c.Param("filepath") == "/api/users" {
+				handleUsers(c)
+				return
+			}
+			// 处理 /api/audit 路由的请求
+			if c.Param("filepath") == "/api/audit" {
+				handleAudit(c)
+				return
+			}
 			// 处理 /api/new-save 路由的请求
 			if c.Param("filepath") == "/api/new-save" && c.Request.Method == http.MethodPost {
 				handleCreateSaveFile(c)
@@ -107,7 +168,7 @@ func getContentType(path string) string {
 }
 
 // HandleLoginRequest处理登录请求
-func HandleLoginRequest(c *gin.Context, config config.Config) {
+func HandleLoginRequest(c *gin.Context, jsonconfig config.Config) {
 	var json struct {
 		Username string `json:"username" binding:"required"`
 		Password string `json:"password" binding:"required"`
@@ -118,19 +179,56 @@ func HandleLoginRequest(c *gin.Context, config config.Config) {
 		return
 	}
 
-	if checkCredentials(json.Username, json.Password, config) {
+	// 用户名或IP被锁定时不检查密码
+	accountKey := lockout.Key(lockout.KindAccount, json.Username)
+	ipKey := lockout.Key(lockout.KindIP, c.ClientIP())
+	now := time.Now()
+	if record, locked, err := lockout.Check(now, accountKey, ipKey); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	} else if locked {
+		fmt.Printf("用户[%v]登入被拒绝: %v已锁定 来源:%v\n", json.Username, record.Key, c.ClientIP())
+		auditAction(c, User{Name: json.Username}, "login_failed", map[string]interface{}{"reason": "locked", "key": record.Key})
+		c.Header("Retry-After", strconv.Itoa(int(record.LockedUntil.Sub(now).Seconds())+1))
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"isLoggedIn":   false,
+			"error":        "Too many failed login attempts, try again later",
+			"locked_until": record.LockedUntil.Format("2006-01-02 15:04:05"),
+		})
+		return
+	}
+
+	user, valid := checkCredentials(json.Username, json.Password)
+	// 只记录用户名、来源和结果,不记录密码
+	if valid {
+		fmt.Printf("用户[%v]登入成功 来源:%v\n", json.Username, c.ClientIP())
+		auditAction(c, user, "login", map[string]interface{}{"user_agent": c.Request.UserAgent()})
+		if err := lockout.Succeed(now, accountKey); err != nil {
+			log.Printf("Failed to clear login failures: %v", err)
+		}
+	} else {
+		fmt.Printf("用户[%v]登入失败 来源:%v\n", json.Username, c.ClientIP())
+		auditAction(c, User{Name: json.Username}, "login_failed", map[string]interface{}{"reason": "invalid_credentials"})
+		recordLoginFailure(jsonconfig, now, json.Username, c.ClientIP(), accountKey, ipKey)
+	}
+
+	if valid {
 		// 如果验证成功，设置cookie
-		cookieValue, err := GenerateCookie()
+		cookieValue, session, err := GenerateCookie(user.Name, c.ClientIP(), c.Request.UserAgent())
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate cookie"})
 			return
 		}
 
-		c.SetCookie("login_cookie", cookieValue, 3600*24, "/", "", false, true)
+		setSessionCookies(c, jsonconfig.UseHttps, cookieValue, session.CSRF, CookieMaxAge)
 
+		// 会话cookie是HttpOnly的,不在响应中返回,自动化调用请使用API令牌
 		c.JSON(http.StatusOK, gin.H{
-			"isLoggedIn": true,
-			"cookie":     cookieValue,
+			"isLoggedIn":         true,
+			"csrfToken":          session.CSRF,
+			"mustChangePassword": user.MustChangePassword,
+			"user":               user.Name,
+			"role":               user.Role,
 		})
 	} else {
 		c.JSON(http.StatusUnauthorized, gin.H{
@@ -139,29 +237,56 @@ func HandleLoginRequest(c *gin.Context, config config.Config) {
 	}
 }
 
-func checkCredentials(username, password string, jsonconfig config.Config) bool {
-	serverUsername := jsonconfig.Account
-	serverPassword := jsonconfig.Password
-	fmt.Printf("有用户正尝试使用 用户名:%v 密码:%v 进行登入\n", username, password)
-	fmt.Printf("A user is attempting to log in with Username: %v Password: %v\n", username, password)
-
-	fmt.Printf("请使用默认登入用户[%v] 默认密码[%v] 进行登入,不包含[],遇到问题可到QQ群:196173384 请教\n", serverUsername, serverPassword)
-	fmt.Printf("please use default account[%v] default password[%v] to login, not include []\n", serverUsername, serverPassword)
-	return username == serverUsername && password == serverPassword
+// recordLoginFailure 记录登入失败,达到次数后锁定用户名或IP并写入审计日志
+func recordLoginFailure(jsonconfig config.Config, now time.Time, username, ip, accountKey, ipKey string) {
+	base := time.Duration(jsonconfig.LoginLockoutMinutes) * time.Minute
+	policies := []lockout.Policy{
+		{MaxAttempts: jsonconfig.LoginMaxAttempts, BaseLockout: base},
+		{MaxAttempts: jsonconfig.LoginMaxAttemptsPerIP, BaseLockout: base},
+	}
+	locked, err := lockout.Fail(now, policies, accountKey, ipKey)
+	if err != nil {
+		log.Printf("Failed to record login failure: %v", err)
+		return
+	}
+	for _, record := range locked {
+		fmt.Printf("登入失败次数过多,%v已锁定到%v 来源:%v\n", record.Key, record.LockedUntil.Format("2006-01-02 15:04:05"), ip)
+		audit.Record(audit.Entry{
+			Action: "login_lockout",
+			User:   username,
+			IP:     ip,
+			Detail: map[string]interface{}{
+				"key":          record.Key,
+				"locked_until": record.LockedUntil,
+				"lockouts":     record.Lockouts,
+			},
+		})
+	}
 }
 
 // HandleCheckLoginStatusRequest 检查登录状态的处理函数
 func HandleCheckLoginStatusRequest(c *gin.Context) {
+	// 使用API令牌时返回令牌对应的用户
+	if token, ok := bearerToken(c); ok {
+		user, err := tokenUser(token, c.ClientIP())
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{"isLoggedIn": false, "error": "Invalid token"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"isLoggedIn": true, "user": user.Name, "role": user.Role, "token": user.Token})
+		return
+	}
+
 	// 从请求中获取cookie
-	cookieValue, err := c.Cookie("login_cookie")
+	cookieValue, err := c.Cookie(CookieName)
 	if err != nil {
 		// 如果cookie不存在，而不是返回BadRequest(400)，我们返回一个OK(200)的响应
 		c.JSON(http.StatusOK, gin.H{"isLoggedIn": false, "error": "Cookie not provided"})
 		return
 	}
 
-	// 使用ValidateCookie函数验证cookie
-	isValid, err := ValidateCookie(cookieValue)
+	// 使用LookupSession函数验证cookie
+	session, err := LookupSession(cookieValue)
 	if err != nil {
 		switch err {
 		case ErrCookieNotFound:
@@ -174,40 +299,87 @@ func HandleCheckLoginStatusRequest(c *gin.Context) {
 		return
 	}
 
-	if isValid {
-		c.JSON(http.StatusOK, gin.H{"isLoggedIn": true})
-	} else {
-		c.JSON(http.StatusOK, gin.H{"isLoggedIn": false, "error": "Invalid cookie"})
+	// 开启防CSRF之前创建的会话没有令牌,需要重新登入
+	if session.CSRF == "" {
+		c.JSON(http.StatusOK, gin.H{"isLoggedIn": false, "error": "Session has no CSRF token, please log in again"})
+		return
 	}
-}
 
-// handleRunCommand 处理 /run 路由的请求
-func handleRunCommand(c *gin.Context) {
-
-	// 从请求中获取cookie
-	cookieValue, err := c.Cookie("login_cookie")
+	user, err := getUser(session.User)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized: Cookie not provided"})
+		c.JSON(http.StatusOK, gin.H{"isLoggedIn": false, "error": "User no longer exists"})
 		return
 	}
+	c.JSON(http.StatusOK, gin.H{
+		"isLoggedIn":         true,
+		"csrfToken":          session.CSRF,
+		"mustChangePassword": user.MustChangePassword,
+		"user":               user.Name,
+		"role":               user.Role,
+	})
+}
 
-	// 使用ValidateCookie函数验证cookie
-	isValid, err := ValidateCookie(cookieValue)
-	if err != nil || !isValid {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized: Invalid cookie"})
+// handleRunCommand 处理 /run 路由的请求
+func handleRunCommand(c *gin.Context, jsonconfig config.Config) {
+	user, ok := authorize(c, RoleOperator)
+	if !ok {
 		return
 	}
 
 	// 从请求中提取参数
 	params := c.Request.URL.Query()
 
+	// 操作员只能运行已保存的模板
+	if !user.hasRole(RoleAdmin) {
+		var err error
+		if params, err = templateParams(params); err != nil {
+			abortAuth(c, http.StatusForbidden, ErrCodeForbidden, err.Error())
+			return
+		}
+	}
+
+	// 目标较多或私聊模式的任务需要另一个用户审批后才启动,先在后台预演确认目标数量
+	if a := checkApproval(jsonconfig, params); a != nil {
+		a.RequesterIP = c.ClientIP()
+		if err := requestRunApproval(a, user.Name); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		startPreview(jsonconfig, *a)
+		auditAction(c, user, "approval_request", map[string]interface{}{
+			"id":     a.ID,
+			"kind":   a.Kind,
+			"params": audit.RedactParams(a.Params),
+		})
+		c.JSON(http.StatusAccepted, gin.H{"message": "Previewing, the job starts after the preview unless approval is required", "approval": a.view(false)})
+		return
+	}
+
+	launcher := audit.Launcher{User: user.Name, IP: c.ClientIP(), JobID: audit.NewJobID()}
+	err := startRunProcess(buildRunArgs(params), launcher)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	auditAction(c, user, "job_start", map[string]interface{}{
+		"source": "webui",
+		"job":    launcher.JobID,
+		"params": audit.RedactParams(paramsMap(params)),
+	})
+
+	// 响应成功启动的信息
+	c.JSON(200, gin.H{"message": "Process started successfully"})
+}
+
+// buildRunArgs 将 /run 的请求参数转换为命令行参数
+func buildRunArgs(params url.Values) []string {
 	// 构建命令行参数
 	args := []string{}
 	for key, values := range params {
 		if len(values) > 0 {
 			for _, val := range values {
 				if val != "" { // 确保 val 不为空
-					if val == "true" && (key == "g" || key == "f" || key == "r") {
+					if val == "true" && boolParams[key] {
 						// 对于布尔型参数，如果值为"true"，只添加参数名
 						args = append(args, fmt.Sprintf("-%s", key))
 						break // 仅需要添加一次参数名
@@ -219,33 +391,28 @@ func handleRunCommand(c *gin.Context) {
 			}
 		}
 	}
+	return args
+}
 
-	// 在Windows上启动新窗口来运行程序
-	cmdLine := fmt.Sprintf("cmd.exe /c start %s %s", os.Args[0], strings.Join(args, " "))
-	cmd := exec.Command("cmd.exe", "/c", cmdLine)
-	err = cmd.Start()
-	if err != nil {
-		c.JSON(500, gin.H{"error": err.Error()})
-		return
+// startRunProcess 在新窗口中启动发送任务,WebUI和定时任务共用
+// 直接启动程序本身而不经过cmd.exe,参数值不会被当作命令解析
+func startRunProcess(args []string, launcher audit.Launcher) error {
+	cmd := exec.Command(os.Args[0], args...)
+	// WebUI已经记录了job_start,任务进程只记录结束,使用相同的启动者和任务ID
+	cmd.Env = append(os.Environ(), launcher.Env()...)
+	setNewConsole(cmd)
+	if err := cmd.Start(); err != nil {
+		return err
 	}
-
-	// 响应成功启动的信息
-	c.JSON(200, gin.H{"message": "Process started successfully"})
+	// 回收结束的进程,任务的结果记录在-save存档中
+	go cmd.Wait()
+	return nil
 }
 
 // handleCreateSaveFile 处理 /new-save 路由的请求
 func handleCreateSaveFile(c *gin.Context) {
-	// 从请求中获取cookie
-	cookieValue, err := c.Cookie("login_cookie")
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized: Cookie not provided"})
-		return
-	}
-
-	// 使用ValidateCookie函数验证cookie
-	isValid, err := ValidateCookie(cookieValue)
-	if err != nil || !isValid {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized: Invalid cookie"})
+	user, ok := authorize(c, RoleOperator)
+	if !ok {
 		return
 	}
 
@@ -282,12 +449,21 @@ func handleCreateSaveFile(c *gin.Context) {
 	}
 	defer file.Close()
 
+	auditAction(c, user, "save_file_create", map[string]interface{}{"file": saveFileName})
+
 	// 响应文件创建成功的信息
 	c.JSON(http.StatusOK, gin.H{"message": "File created successfully", "filePath": filePath})
 }
 
 // handleListFiles 处理 /list-files 路由的请求
 func handleListFiles(c *gin.Context) {
+	user, ok := authorize(c, RoleViewer)
+	if !ok {
+		return
+	}
+	// 只读用户不能看到模板中的access_token,操作员重新运行模板时需要完整的参数
+	redact := !user.hasRole(RoleOperator)
+
 	executablePath, err := os.Executable()
 	if err != nil {
 		c.JSON(500, gin.H{"error": "Unable to get executable path"})
@@ -324,6 +500,9 @@ func handleListFiles(c *gin.Context) {
 				decodedContentStr = strings.ReplaceAll(decodedContentStr, " -g ", " -g true ")
 				decodedContentStr = strings.ReplaceAll(decodedContentStr, " -f ", " -f true ")
 				decodedContentStr = strings.ReplaceAll(decodedContentStr, " -r ", " -r true ")
+				if redact {
+					decodedContentStr = audit.RedactCommandLine(decodedContentStr)
+				}
 
 				batchFiles = append(batchFiles, BatchFile{
 					Filename: info.Name(),
@@ -341,3 +520,32 @@ func handleListFiles(c *gin.Context) {
 	c.Writer.Header().Set("Content-Type", "application/json; charset=utf-8")
 	c.JSON(200, gin.H{"textFiles": textFiles, "batchFiles": batchFiles})
 }
+
+// handlePreview 处理 /preview 路由的请求,返回预演模式生成的报告
+func handlePreview(c *gin.Context) {
+	if _, ok := authorize(c, RoleViewer); !ok {
+		return
+	}
+
+	// 存档名与运行时的-s参数一致
+	saveName := c.Query("s")
+	if saveName == "" || strings.ContainsAny(saveName, `/\`) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid save name"})
+		return
+	}
+
+	executablePath, err := os.Executable()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not determine executable path"})
+		return
+	}
+	filePath := filepath.Join(filepath.Dir(executablePath), saveName+"-preview.json")
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Preview report not found"})
+		return
+	}
+
+	c.Data(http.StatusOK, "application/json; charset=utf-8", data)
+}
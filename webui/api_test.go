@@ -0,0 +1,50 @@
+package webui
+
+import (
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// flagGroups 将参数按参数名分组并排序,buildRunArgs按map的顺序输出
+func flagGroups(args []string) []string {
+	var groups []string
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") || len(groups) == 0 {
+			groups = append(groups, arg)
+			continue
+		}
+		groups[len(groups)-1] += "\x00" + arg
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+func TestBuildRunArgs(t *testing.T) {
+	tests := []struct {
+		params url.Values
+		want   []string
+	}{
+		{url.Values{}, nil},
+		{url.Values{"a": {"http://127.0.0.1:8080"}, "d": {"30"}}, []string{"-a\x00http://127.0.0.1:8080", "-d\x0030"}},
+		// 布尔参数只添加参数名
+		{url.Values{"g": {"true"}, "f": {"true"}, "r": {"true"}, "n": {"true"}}, []string{"-f", "-g", "-n", "-r"}},
+		{url.Values{"g": {"true", "true"}}, []string{"-g"}},
+		{url.Values{"w": {"true"}}, []string{"-w\x00true"}},
+		// 空值不添加
+		{url.Values{"t": {""}, "s": {"list"}}, []string{"-s\x00list"}},
+		// 多个值重复参数名
+		{url.Values{"w": {"早上好", "晚上好"}}, []string{"-w\x00早上好", "-w\x00晚上好"}},
+		// 参数值原样作为一个参数传递,不经过shell解析
+		{url.Values{"w": {`a & del x | "b" %PATH% ^c`}}, []string{"-w\x00" + `a & del x | "b" %PATH% ^c`}},
+		{url.Values{"w": {"第一行\n第二行"}}, []string{"-w\x00第一行\n第二行"}},
+	}
+	for _, tt := range tests {
+		args := buildRunArgs(tt.params)
+		if got := flagGroups(args); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("buildRunArgs(%v) = %q, want %q", tt.params, args, tt.want)
+		}
+	}
+}
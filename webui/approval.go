@@ -0,0 +1,502 @@
+package webui
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/hoshinonyaruko/gensokyo-broadcast/audit"
+	"github.com/hoshinonyaruko/gensokyo-broadcast/config"
+)
+
+const ApprovalBucket = "approvals"
+
+// 审批状态
+const (
+	ApprovalPreviewing  = "pending_preview" // 预演中,预演完成后判断是否需要审批
+	ApprovalNotRequired = "not_required"    // 预演后不需要审批,立即运行的任务已启动
+	ApprovalPending     = "pending"
+	ApprovalApproved    = "approved"
+	ApprovalRejected    = "rejected"
+	ApprovalExpired     = "expired" // 审批前后任务被修改或重新提交
+)
+
+// 需要审批的任务类型
+const (
+	ApprovalKindRun      = "run"      // 通过/run立即运行,审批通过后立即启动
+	ApprovalKindSchedule = "schedule" // 定时任务,审批通过后才会按时执行
+)
+
+// 审批页面显示的预演目标数量,报告中的统计仍然是全部目标
+const approvalPreviewItems = 50
+
+// 审批前预演的最长时间,获取很大的群列表时需要较长时间
+const approvalPreviewTimeout = 5 * time.Minute
+
+var ErrApprovalNotFound = errors.New("approval not found")
+
+// Approval 需要另一个用户审批的任务,以json保存在bolt中
+type Approval struct {
+	ID           string            `json:"id"`
+	Kind         string            `json:"kind"`
+	ScheduleID   string            `json:"schedule_id,omitempty"`
+	Name         string            `json:"name"` // 存档名或定时任务名
+	Params       map[string]string `json:"params"`
+	When         string            `json:"when"` // 执行时间,立即执行时为空
+	Hash         string            `json:"hash"` // 参数和执行时间的哈希,任务被修改后不再匹配
+	Reason       string            `json:"reason"`
+	Targets      int               `json:"targets"`
+	ToSend       int               `json:"to_send"`
+	FriendMode   bool              `json:"friend_mode"`
+	Preview      json.RawMessage   `json:"preview,omitempty"` // 预演报告,只保留前approvalPreviewItems个目标
+	PreviewError string            `json:"preview_error,omitempty"`
+	Status       string            `json:"status"`
+	Requester    string            `json:"requester"`
+	RequesterIP  string            `json:"requester_ip,omitempty"` // 不需要审批时以提交者的身份启动任务
+	Approver     string            `json:"approver,omitempty"`     // 审批或拒绝的用户
+	Comment      string            `json:"comment,omitempty"`
+	RunError     string            `json:"run_error,omitempty"`
+	Created      time.Time         `json:"created"`
+	Decided      time.Time         `json:"decided"`
+}
+
+// approvalView 返回给WebUI的审批,access_token不显示
+type approvalView struct {
+	ID           string            `json:"id"`
+	Kind         string            `json:"kind"`
+	ScheduleID   string            `json:"schedule_id,omitempty"`
+	Name         string            `json:"name"`
+	Params       map[string]string `json:"params"`
+	When         string            `json:"when,omitempty"`
+	Reason       string            `json:"reason"`
+	Targets      int               `json:"targets"`
+	ToSend       int               `json:"to_send"`
+	FriendMode   bool              `json:"friend_mode"`
+	Preview      json.RawMessage   `json:"preview,omitempty"`
+	PreviewError string            `json:"preview_error,omitempty"`
+	Status       string            `json:"status"`
+	Requester    string            `json:"requester"`
+	Approver     string            `json:"approver,omitempty"`
+	Comment      string            `json:"comment,omitempty"`
+	RunError     string            `json:"run_error,omitempty"`
+	Created      string            `json:"created"`
+	Decided      string            `json:"decided,omitempty"`
+}
+
+func (a Approval) view(withPreview bool) approvalView {
+	params := audit.RedactParams(a.Params)
+	v := approvalView{
+		ID:           a.ID,
+		Kind:         a.Kind,
+		ScheduleID:   a.ScheduleID,
+		Name:         a.Name,
+		Params:       params,
+		When:         a.When,
+		Reason:       a.Reason,
+		Targets:      a.Targets,
+		ToSend:       a.ToSend,
+		FriendMode:   a.FriendMode,
+		PreviewError: a.PreviewError,
+		Status:       a.Status,
+		Requester:    a.Requester,
+		Approver:     a.Approver,
+		Comment:      a.Comment,
+		RunError:     a.RunError,
+		Created:      formatScheduleTime(a.Created),
+		Decided:      formatScheduleTime(a.Decided),
+	}
+	if withPreview {
+		v.Preview = a.Preview
+	}
+	return v
+}
+
+// campaignHash 计算任务参数和执行时间的哈希,审批只对同样的参数和时间有效
+func campaignHash(params map[string]string, when string) string {
+	data, _ := json.Marshal(struct {
+		Params map[string]string `json:"params"`
+		When   string            `json:"when"`
+	}{params, when})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func paramsMap(params url.Values) map[string]string {
+	m := make(map[string]string, len(params))
+	for key := range params {
+		if value := params.Get(key); value != "" {
+			m[key] = value
+		}
+	}
+	return m
+}
+
+func paramsValues(m map[string]string) url.Values {
+	params := url.Values{}
+	for key, value := range m {
+		params.Set(key, value)
+	}
+	return params
+}
+
+func loadApproval(tx *bolt.Tx, id string) (Approval, error) {
+	var a Approval
+	data := tx.Bucket([]byte(ApprovalBucket)).Get([]byte(id))
+	if data == nil {
+		return a, ErrApprovalNotFound
+	}
+	err := json.Unmarshal(data, &a)
+	return a, err
+}
+
+func loadApprovals(tx *bolt.Tx) ([]Approval, error) {
+	var approvals []Approval
+	err := tx.Bucket([]byte(ApprovalBucket)).ForEach(func(k, v []byte) error {
+		var a Approval
+		if err := json.Unmarshal(v, &a); err != nil {
+			return err
+		}
+		approvals = append(approvals, a)
+		return nil
+	})
+	return approvals, err
+}
+
+func putApproval(tx *bolt.Tx, a Approval) error {
+	data, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket([]byte(ApprovalBucket)).Put([]byte(a.ID), data)
+}
+
+// expireApprovals 使匹配的待审批和已审批的任务失效,用于任务被修改或重新提交
+func expireApprovals(tx *bolt.Tx, match func(Approval) bool) error {
+	approvals, err := loadApprovals(tx)
+	if err != nil {
+		return err
+	}
+	for _, a := range approvals {
+		if (a.Status == ApprovalPreviewing || a.Status == ApprovalPending || a.Status == ApprovalApproved) && match(a) {
+			a.Status = ApprovalExpired
+			a.Decided = time.Now()
+			if err := putApproval(tx, a); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func initApprovalBucket() {
+	err := dbcookie.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(ApprovalBucket))
+		return err
+	})
+	if err != nil {
+		log.Fatalf("Error creating approval bucket: %v", err)
+	}
+}
+
+// runPreview 以预演模式(-n)运行任务,返回预演报告。
+// 报告写在程序目录下,每个审批使用单独的文件,同一存档名的多个审批不会互相覆盖
+func runPreview(params url.Values, id string) (map[string]json.RawMessage, error) {
+	executablePath, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("could not determine executable path: %w", err)
+	}
+	reportPath := filepath.Join(filepath.Dir(executablePath), "approval-"+id+"-preview.json")
+	defer os.Remove(reportPath)
+
+	ctx, cancel := context.WithTimeout(context.Background(), approvalPreviewTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, os.Args[0], append(buildRunArgs(params), "-n", "-report", reportPath)...)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("preview failed: %s", lastLine(stderr.String()))
+	}
+
+	data, err := os.ReadFile(reportPath)
+	if err != nil {
+		return nil, fmt.Errorf("preview report not found: %w", err)
+	}
+	var report map[string]json.RawMessage
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("invalid preview report: %w", err)
+	}
+	return report, nil
+}
+
+// checkApproval 判断任务是否可能需要审批,需要预演确认时返回预演中的审批记录,不需要时返回nil。
+// 预演(n)不需要审批
+func checkApproval(jsonconfig config.Config, params url.Values) *Approval {
+	if jsonconfig.ApprovalThreshold <= 0 && !jsonconfig.ApprovalFriendMode {
+		return nil
+	}
+	if params.Get("n") == "true" {
+		return nil
+	}
+	return &Approval{
+		ID:         uuid.New().String()[:8],
+		Params:     paramsMap(params),
+		Status:     ApprovalPreviewing,
+		Targets:    -1,
+		ToSend:     -1,
+		FriendMode: params.Get("f") == "true",
+		Created:    time.Now(),
+	}
+}
+
+// applyPreview 根据预演结果设置审批原因,返回是否需要审批。
+// 预演失败时无法确认目标数量,按需要审批处理
+func (a *Approval) applyPreview(jsonconfig config.Config, report map[string]json.RawMessage, previewErr error) bool {
+	var reasons []string
+	if a.FriendMode && jsonconfig.ApprovalFriendMode {
+		reasons = append(reasons, "friend mode")
+	}
+	if previewErr != nil {
+		a.PreviewError = previewErr.Error()
+		if jsonconfig.ApprovalThreshold > 0 {
+			reasons = append(reasons, "target count unknown")
+		}
+	} else {
+		json.Unmarshal(report["targets"], &a.Targets)
+		json.Unmarshal(report["to_send"], &a.ToSend)
+		if jsonconfig.ApprovalThreshold > 0 && a.ToSend > jsonconfig.ApprovalThreshold {
+			reasons = append(reasons, fmt.Sprintf("%d targets exceed the approval threshold %d", a.ToSend, jsonconfig.ApprovalThreshold))
+		}
+		var items []json.RawMessage
+		if json.Unmarshal(report["items"], &items) == nil && len(items) > approvalPreviewItems {
+			report["items"], _ = json.Marshal(items[:approvalPreviewItems])
+		}
+		a.Preview, _ = json.Marshal(report)
+	}
+	a.Reason = strings.Join(reasons, ", ")
+	return len(reasons) > 0
+}
+
+// startPreview 在后台预演任务,请求不需要等待预演完成。预演期间审批为pending_preview,
+// 完成后需要审批的变为pending;不需要审批的立即运行任务由提交的用户启动,定时任务按时执行
+func startPreview(jsonconfig config.Config, a Approval) {
+	go func() {
+		report, previewErr := runPreview(paramsValues(a.Params), a.ID)
+		started := false
+		err := dbcookie.Update(func(tx *bolt.Tx) error {
+			current, err := loadApproval(tx, a.ID)
+			if err != nil {
+				return err
+			}
+			// 预演期间任务被修改或重新提交,审批已失效
+			if current.Status != ApprovalPreviewing {
+				return nil
+			}
+			a = current
+			if a.applyPreview(jsonconfig, report, previewErr) {
+				a.Status = ApprovalPending
+				return putApproval(tx, a)
+			}
+			a.Status = ApprovalNotRequired
+			a.Decided = time.Now()
+			if a.Kind == ApprovalKindSchedule && approveSchedule(tx, a) != nil {
+				// 定时任务在预演期间被删除或修改
+				a.Status = ApprovalExpired
+			}
+			started = a.Kind == ApprovalKindRun
+			return putApproval(tx, a)
+		})
+		if err != nil {
+			log.Printf("Failed to save preview of approval %s: %v", a.ID, err)
+			return
+		}
+		if a.Status == ApprovalPending {
+			log.Printf("用户[%s]提交的%s[%s]%s需要审批: %s", a.Requester, a.Kind, a.ID, a.Name, a.Reason)
+		}
+		if !started {
+			return
+		}
+		launcher := audit.Launcher{User: a.Requester, IP: a.RequesterIP, JobID: audit.NewJobID()}
+		if startApprovedRun(&a, launcher) == nil {
+			audit.Record(audit.Entry{
+				Action: "job_start",
+				User:   launcher.User,
+				IP:     launcher.IP,
+				Detail: map[string]interface{}{
+					"source":   "webui",
+					"job":      launcher.JobID,
+					"approval": a.ID,
+					"params":   audit.RedactParams(a.Params),
+				},
+			})
+		}
+	}()
+}
+
+// requestRunApproval 为/run创建审批,同一存档名之前提交的任务失效
+func requestRunApproval(a *Approval, requester string) error {
+	a.Kind = ApprovalKindRun
+	a.Name = a.Params["s"]
+	a.Requester = requester
+	a.Hash = campaignHash(a.Params, "")
+	return dbcookie.Update(func(tx *bolt.Tx) error {
+		err := expireApprovals(tx, func(old Approval) bool {
+			return old.Kind == ApprovalKindRun && old.Name == a.Name
+		})
+		if err != nil {
+			return err
+		}
+		return putApproval(tx, *a)
+	})
+}
+
+// startApprovedRun 启动审批通过或不需要审批的立即运行任务,启动失败时记录在审批中
+func startApprovedRun(a *Approval, launcher audit.Launcher) error {
+	err := startRunProcess(buildRunArgs(paramsValues(a.Params)), launcher)
+	if err != nil {
+		log.Printf("任务[%s]%s启动失败: %v", a.ID, a.Name, err)
+		a.RunError = err.Error()
+		dbcookie.Update(func(tx *bolt.Tx) error {
+			return putApproval(tx, *a)
+		})
+	}
+	return err
+}
+
+// approvalDecision 审批请求体
+type approvalDecision struct {
+	Action  string `json:"action"` // approve 或 reject
+	Comment string `json:"comment"`
+}
+
+// handleApprovals 处理 /approvals 路由的请求,GET列出或查看单个审批,POST审批或拒绝。
+// 审批需要操作员,且不能审批自己提交的任务
+func handleApprovals(c *gin.Context) {
+	role := RoleOperator
+	if c.Request.Method == http.MethodGet {
+		role = RoleViewer
+	}
+	user, ok := authorize(c, role)
+	if !ok {
+		return
+	}
+
+	switch c.Request.Method {
+	case http.MethodGet:
+		id := c.Query("id")
+		var approvals []Approval
+		err := dbcookie.View(func(tx *bolt.Tx) error {
+			if id != "" {
+				a, err := loadApproval(tx, id)
+				approvals = append(approvals, a)
+				return err
+			}
+			var err error
+			approvals, err = loadApprovals(tx)
+			return err
+		})
+		if err == ErrApprovalNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if id != "" {
+			c.JSON(http.StatusOK, gin.H{"approval": approvals[0].view(true)})
+			return
+		}
+		views := make([]approvalView, 0, len(approvals))
+		for _, a := range approvals {
+			views = append(views, a.view(false))
+		}
+		c.JSON(http.StatusOK, gin.H{"approvals": views})
+	case http.MethodPost:
+		var requestBody approvalDecision
+		if err := c.BindJSON(&requestBody); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Bad request"})
+			return
+		}
+		if requestBody.Action != "approve" && requestBody.Action != "reject" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid action, use approve or reject"})
+			return
+		}
+
+		var a Approval
+		err := dbcookie.Update(func(tx *bolt.Tx) error {
+			var err error
+			if a, err = loadApproval(tx, c.Query("id")); err != nil {
+				return err
+			}
+			if a.Status != ApprovalPending {
+				return fmt.Errorf("approval is %s", a.Status)
+			}
+			if a.Requester == user.Name {
+				return errors.New("approval must come from a different user")
+			}
+			a.Approver = user.Name
+			a.Comment = requestBody.Comment
+			a.Decided = time.Now()
+			a.Status = ApprovalRejected
+			if requestBody.Action == "approve" {
+				a.Status = ApprovalApproved
+				if a.Kind == ApprovalKindSchedule {
+					if err := approveSchedule(tx, a); err != nil {
+						return err
+					}
+				}
+			}
+			return putApproval(tx, a)
+		})
+		if err == ErrApprovalNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("用户[%s]%s了%s[%s]%s", user.Name, map[string]string{"approve": "审批通过", "reject": "拒绝"}[requestBody.Action], a.Kind, a.ID, a.Name)
+		auditAction(c, user, "approval_"+requestBody.Action, map[string]interface{}{
+			"id":        a.ID,
+			"kind":      a.Kind,
+			"name":      a.Name,
+			"requester": a.Requester,
+			"comment":   a.Comment,
+		})
+
+		// 立即运行的任务审批通过后由审批人启动
+		if a.Kind == ApprovalKindRun && a.Status == ApprovalApproved {
+			launcher := audit.Launcher{User: user.Name, IP: c.ClientIP(), JobID: audit.NewJobID()}
+			if err := startApprovedRun(&a, launcher); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "approval": a.view(false)})
+				return
+			}
+			auditAction(c, user, "job_start", map[string]interface{}{
+				"source":    "approval",
+				"job":       launcher.JobID,
+				"approval":  a.ID,
+				"requester": a.Requester,
+				"params":    audit.RedactParams(a.Params),
+			})
+		}
+		c.JSON(http.StatusOK, gin.H{"approval": a.view(false)})
+	default:
+		c.JSON(http.StatusMethodNotAllowed, gin.H{"error": "Method not allowed"})
+	}
+}
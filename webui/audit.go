@@ -0,0 +1,105 @@
+package webui
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hoshinonyaruko/gensokyo-broadcast/audit"
+)
+
+// 默认返回的审计记录数量
+const auditDefaultLimit = 100
+
+// auditAction 记录操作的用户和来源IP,使用API令牌时同时记录令牌名称
+func auditAction(c *gin.Context, user User, action string, detail map[string]interface{}) {
+	if user.Token != "" {
+		if detail == nil {
+			detail = map[string]interface{}{}
+		}
+		detail["via_token"] = user.Token
+	}
+	audit.Record(audit.Entry{
+		Action: action,
+		User:   user.Name,
+		IP:     c.ClientIP(),
+		Detail: detail,
+	})
+}
+
+// parseAuditTime 解析查询的时间,支持日期或日期加时间,使用本地时间
+func parseAuditTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02 15:04:05", value, time.Local); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", value, time.Local)
+}
+
+// handleAudit 处理 /audit 路由的请求,需要管理员。可以按action、user、ip、since、until筛选,
+// 默认返回最新的100条;format=jsonl时按时间顺序导出全部满足条件的记录
+func handleAudit(c *gin.Context) {
+	user, ok := authorize(c, RoleAdmin)
+	if !ok {
+		return
+	}
+	if c.Request.Method != http.MethodGet {
+		c.JSON(http.StatusMethodNotAllowed, gin.H{"error": "Method not allowed"})
+		return
+	}
+
+	filter := audit.Filter{
+		Action: c.Query("action"),
+		User:   c.Query("user"),
+		IP:     c.Query("ip"),
+	}
+	var err error
+	if filter.Since, err = parseAuditTime(c.Query("since")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid since, use 2006-01-02 or 2006-01-02 15:04:05"})
+		return
+	}
+	if filter.Until, err = parseAuditTime(c.Query("until")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid until, use 2006-01-02 or 2006-01-02 15:04:05"})
+		return
+	}
+
+	if c.Query("format") == "jsonl" {
+		auditAction(c, user, "audit_export", map[string]interface{}{
+			"action": filter.Action,
+			"user":   filter.User,
+			"ip":     filter.IP,
+			"since":  c.Query("since"),
+			"until":  c.Query("until"),
+		})
+		filename := fmt.Sprintf("audit-%s.jsonl", time.Now().Format("20060102-150405"))
+		c.Header("Content-Type", "application/x-ndjson; charset=utf-8")
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+		c.Status(http.StatusOK)
+		if err := audit.Export(c.Writer, filter); err != nil {
+			log.Printf("Failed to export audit log: %v", err)
+		}
+		return
+	}
+
+	limit := auditDefaultLimit
+	if value := c.Query("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+	}
+	entries, err := audit.Query(filter, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if entries == nil {
+		entries = []audit.Entry{}
+	}
+	c.JSON(http.StatusOK, gin.H{"entries": entries})
+}
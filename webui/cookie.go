@@ -1,7 +1,10 @@
 package webui
 
 import (
+	"crypto/sha256"
 	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"log"
 	"time"
@@ -14,9 +17,14 @@ const (
 	DBName          = "cookie.db"
 	CookieBucket    = "cookies"
 	ExpirationKey   = "expiration"
-	ExpirationHours = 30 * 24 // Cookie 有效期改为一个月
+	ExpirationHours = 30 * 24 // Cookie 有效期改为一个月,浏览器中的cookie和数据库中的会话使用同样的有效期
+	CookieName      = "login_cookie"
+	CookieMaxAge    = ExpirationHours * 3600 // 浏览器中cookie的有效期,单位秒
 )
 
+// 过期会话的清理间隔
+const sessionSweepInterval = time.Hour
+
 var dbcookie *bolt.DB
 var ErrCookieNotFound = errors.New("cookie not found")
 var ErrCookieExpired = errors.New("cookie has expired")
@@ -38,50 +46,157 @@ func CloseDB() {
 	dbcookie.Close()
 }
 
-func GenerateCookie() (string, error) {
+// Session 登入cookie对应的会话
+type Session struct {
+	User       string `json:"user"`       // 登入的用户名
+	Expiration int64  `json:"expiration"` // 过期时间,unix秒
+	Created    int64  `json:"created"`    // 登入时间,unix秒
+	IP         string `json:"ip"`         // 登入时的来源IP
+	UserAgent  string `json:"user_agent"` // 登入时的浏览器
+	CSRF       string `json:"csrf"`       // 防CSRF令牌,修改类请求需要在请求头中带上
+}
+
+// sessionID 返回会话的编号,用于列出和撤销会话,不暴露cookie本身
+func sessionID(cookie string) string {
+	sum := sha256.Sum256([]byte(cookie))
+	return hex.EncodeToString(sum[:8])
+}
+
+// decodeSession 解析会话,兼容只保存了过期时间的旧cookie,旧cookie属于config.json中的用户
+func decodeSession(data []byte) (Session, error) {
+	if len(data) == 8 {
+		return Session{User: builtinAccount(), Expiration: bytesToInt(data)}, nil
+	}
+	var session Session
+	err := json.Unmarshal(data, &session)
+	return session, err
+}
+
+// GenerateCookie 为登入的用户创建会话,记录来源IP和浏览器,返回cookie和会话
+func GenerateCookie(user, ip, userAgent string) (string, Session, error) {
 	cookie := uuid.New().String()
-	expiration := time.Now().Add(ExpirationHours * time.Hour).Unix()
+	now := time.Now()
+	session := Session{
+		User:       user,
+		Expiration: now.Add(ExpirationHours * time.Hour).Unix(),
+		Created:    now.Unix(),
+		IP:         ip,
+		UserAgent:  userAgent,
+		CSRF:       uuid.New().String(),
+	}
+	data, err := json.Marshal(session)
+	if err != nil {
+		return "", Session{}, err
+	}
 
-	err := dbcookie.Update(func(tx *bolt.Tx) error {
+	err = dbcookie.Update(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket([]byte(CookieBucket))
-		if err := bucket.Put([]byte(cookie), intToBytes(expiration)); err != nil {
+		if err := bucket.Put([]byte(cookie), data); err != nil {
 			return err
 		}
 		return nil
 	})
 
 	if err != nil {
-		return "", err
+		return "", Session{}, err
 	}
 
-	return cookie, nil
+	return cookie, session, nil
 }
 
 func ValidateCookie(cookie string) (bool, error) {
-	isValid := false
+	_, err := LookupSession(cookie)
+	return err == nil, err
+}
+
+// LookupSession 返回cookie对应的会话,不存在或过期时返回错误
+func LookupSession(cookie string) (Session, error) {
+	var session Session
 	err := dbcookie.View(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket([]byte(CookieBucket))
-		expBytes := bucket.Get([]byte(cookie))
-		if expBytes == nil {
+		data := bucket.Get([]byte(cookie))
+		if data == nil {
 			return ErrCookieNotFound
 		}
 
-		expiration := bytesToInt(expBytes)
-		if time.Now().Unix() > expiration {
+		var err error
+		if session, err = decodeSession(data); err != nil {
+			return err
+		}
+		if time.Now().Unix() > session.Expiration {
 			return ErrCookieExpired
 		}
+		return nil
+	})
 
-		isValid = true
+	return session, err
+}
+
+// deleteSessions 删除匹配的会话,返回删除的数量。无法解析的旧数据也会交给match判断
+func deleteSessions(tx *bolt.Tx, match func(cookie string, session Session, err error) bool) (int, error) {
+	bucket := tx.Bucket([]byte(CookieBucket))
+	var cookies [][]byte
+	err := bucket.ForEach(func(k, v []byte) error {
+		session, err := decodeSession(v)
+		if match(string(k), session, err) {
+			cookies = append(cookies, k)
+		}
 		return nil
 	})
+	if err != nil {
+		return 0, err
+	}
+	for _, k := range cookies {
+		if err := bucket.Delete(k); err != nil {
+			return 0, err
+		}
+	}
+	return len(cookies), nil
+}
+
+// deleteUserSessions 删除用户的全部会话,用于删除用户或重置密码后让其重新登入
+func deleteUserSessions(tx *bolt.Tx, user string) error {
+	_, err := deleteSessions(tx, func(cookie string, session Session, err error) bool {
+		return err == nil && session.User == user
+	})
+	return err
+}
 
-	return isValid, err
+// DeleteCookie 删除会话,用于登出
+func DeleteCookie(cookie string) error {
+	return dbcookie.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(CookieBucket)).Delete([]byte(cookie))
+	})
 }
 
-func intToBytes(n int64) []byte {
-	bytes := make([]byte, 8)
-	binary.BigEndian.PutUint64(bytes, uint64(n))
-	return bytes
+// SweepExpiredSessions 删除已过期和无法解析的会话,返回删除的数量
+func SweepExpiredSessions() (int, error) {
+	now := time.Now().Unix()
+	var deleted int
+	err := dbcookie.Update(func(tx *bolt.Tx) error {
+		var err error
+		deleted, err = deleteSessions(tx, func(cookie string, session Session, err error) bool {
+			return err != nil || now > session.Expiration
+		})
+		return err
+	})
+	return deleted, err
+}
+
+// StartSessionSweeper 定期清理过期的会话,需要在InitializeDB之后调用
+func StartSessionSweeper() {
+	go func() {
+		ticker := time.NewTicker(sessionSweepInterval)
+		defer ticker.Stop()
+		for {
+			if deleted, err := SweepExpiredSessions(); err != nil {
+				log.Printf("Failed to sweep expired sessions: %v", err)
+			} else if deleted > 0 {
+				log.Printf("已清理%d个过期的登入会话", deleted)
+			}
+			<-ticker.C
+		}
+	}()
 }
 
 func bytesToInt(b []byte) int64 {
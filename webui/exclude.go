@@ -0,0 +1,88 @@
+package webui
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hoshinonyaruko/gensokyo-broadcast/exclude"
+)
+
+// handleExclusions 处理 /exclusions 路由的请求,GET列出,POST添加,DELETE删除,修改需要管理员
+func handleExclusions(c *gin.Context) {
+	role := RoleAdmin
+	if c.Request.Method == http.MethodGet {
+		role = RoleViewer
+	}
+	user, ok := authorize(c, role)
+	if !ok {
+		return
+	}
+
+	switch c.Request.Method {
+	case http.MethodGet:
+		entries, err := exclude.Load()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if entries == nil {
+			entries = []exclude.Entry{}
+		}
+		c.JSON(http.StatusOK, gin.H{"exclusions": entries})
+	case http.MethodPost:
+		var requestBody struct {
+			Type   string `json:"type"`
+			Value  string `json:"value"`
+			Reason string `json:"reason"`
+			Expire string `json:"expire"` // 格式2006-01-02,为空表示永久
+		}
+		if err := c.BindJSON(&requestBody); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Bad request"})
+			return
+		}
+
+		entry := exclude.Entry{
+			Type:   requestBody.Type,
+			Value:  requestBody.Value,
+			Reason: requestBody.Reason,
+			Source: "webui",
+		}
+		if requestBody.Expire != "" {
+			t, err := time.ParseInLocation("2006-01-02", requestBody.Expire, time.Local)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid expire date"})
+				return
+			}
+			// 有效期包含当天
+			entry.Expire = t.Add(24*time.Hour - time.Second)
+		}
+
+		entry, err := exclude.Add(entry)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		auditAction(c, user, "exclusion_add", map[string]interface{}{
+			"id":     entry.ID,
+			"type":   entry.Type,
+			"value":  entry.Value,
+			"reason": entry.Reason,
+		})
+		c.JSON(http.StatusOK, gin.H{"message": "Exclusion added", "exclusion": entry})
+	case http.MethodDelete:
+		id := c.Query("id")
+		if err := exclude.Remove(id); err != nil {
+			if err == exclude.ErrEntryNotFound {
+				c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		auditAction(c, user, "exclusion_remove", map[string]interface{}{"id": id})
+		c.JSON(http.StatusOK, gin.H{"message": "Exclusion removed"})
+	default:
+		c.JSON(http.StatusMethodNotAllowed, gin.H{"error": "Method not allowed"})
+	}
+}
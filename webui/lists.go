@@ -0,0 +1,87 @@
+package webui
+
+import (
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handleListEval 处理 /list-eval 路由的请求,计算列表表达式,可保存为新的列表文件,保存需要管理员
+func handleListEval(c *gin.Context) {
+	user, ok := authorize(c, RoleOperator)
+	if !ok {
+		return
+	}
+
+	var requestBody struct {
+		Expr  string `json:"expr"`
+		A     string `json:"a"`     // HTTP API 的地址
+		T     string `json:"t"`     // access_token
+		Tags  string `json:"tags"`  // 只保留带有这些标签的目标,逗号分隔
+		Save  string `json:"save"`  // 保存结果的列表文件名,为空则只预览
+		Force bool   `json:"force"` // 覆盖已存在的列表文件
+	}
+	if err := c.BindJSON(&requestBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad request"})
+		return
+	}
+	if strings.TrimSpace(requestBody.Expr) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Expr is required"})
+		return
+	}
+	if requestBody.Save != "" && !user.hasRole(RoleAdmin) {
+		abortAuth(c, http.StatusForbidden, ErrCodeForbidden, "Forbidden: requires admin role to save lists")
+		return
+	}
+	if strings.ContainsAny(requestBody.Save, `/\`) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid list name"})
+		return
+	}
+
+	// 与命令行使用同一套逻辑,调用 list eval 子命令
+	args := []string{"list", "eval", "-json"}
+	if requestBody.A != "" {
+		args = append(args, "-a", requestBody.A)
+	}
+	if requestBody.T != "" {
+		args = append(args, "-t", requestBody.T)
+	}
+	if requestBody.Tags != "" {
+		args = append(args, "-tags", requestBody.Tags)
+	}
+	if requestBody.Save != "" {
+		args = append(args, "-o", requestBody.Save)
+	}
+	if requestBody.Force {
+		args = append(args, "-force")
+	}
+	args = append(args, "--", requestBody.Expr)
+
+	cmd := exec.Command(os.Args[0], args...)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": lastLine(stderr.String())})
+		return
+	}
+
+	if requestBody.Save != "" {
+		auditAction(c, user, "list_save", map[string]interface{}{
+			"list":  requestBody.Save,
+			"expr":  requestBody.Expr,
+			"tags":  requestBody.Tags,
+			"force": requestBody.Force,
+		})
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", output)
+}
+
+// lastLine 返回子命令输出的最后一行,通常是失败原因
+func lastLine(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	return lines[len(lines)-1]
+}
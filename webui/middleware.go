@@ -0,0 +1,124 @@
+package webui
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 防CSRF令牌使用的cookie和请求头,与axios的默认值一致,前端不需要额外设置
+const (
+	CSRFCookieName = "XSRF-TOKEN"
+	CSRFHeaderName = "X-XSRF-TOKEN"
+)
+
+// 认证失败时返回的code
+const (
+	ErrCodeUnauthorized           = "unauthorized"             // 未登入或登入已失效
+	ErrCodeForbidden              = "forbidden"                // 角色或令牌权限不足
+	ErrCodePasswordChangeRequired = "password_change_required" // 需要先修改密码
+	ErrCodeCSRF                   = "csrf_failed"              // 缺少或错误的防CSRF令牌
+)
+
+// 认证通过后保存在gin.Context中的用户
+const contextUserKey = "webui_user"
+
+// publicAPIs 不需要登入的接口
+var publicAPIs = map[string]bool{
+	"/api/login":              true,
+	"/api/logout":             true,
+	"/api/check-login-status": true,
+}
+
+// stateChangingGets 虽然是GET请求但会修改状态的接口,同样需要防CSRF令牌
+var stateChangingGets = map[string]bool{
+	"/api/run": true,
+}
+
+// abortAuth 以统一的格式 {"error": ..., "code": ...} 返回认证错误并中止请求
+func abortAuth(c *gin.Context, status int, code, message string) {
+	c.AbortWithStatusJSON(status, gin.H{"error": message, "code": code})
+}
+
+// AuthMiddleware 对 /webui/api 下除登入外的全部接口进行认证,认证通过的用户保存在gin.Context中。
+// 使用cookie登入的修改类请求还需要在请求头中带上防CSRF令牌,使用API令牌的请求不需要
+func AuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path := c.Param("filepath")
+		if !strings.HasPrefix(c.Request.URL.Path, "/webui/api") || publicAPIs[path] {
+			c.Next()
+			return
+		}
+
+		user, session, ok := authenticate(c)
+		if !ok {
+			return
+		}
+		if user.Token == "" && needsCSRF(c.Request.Method, path) && !validCSRF(c.GetHeader(CSRFHeaderName), session.CSRF) {
+			abortAuth(c, http.StatusForbidden, ErrCodeCSRF, "Forbidden: missing or invalid CSRF token")
+			return
+		}
+		c.Set(contextUserKey, user)
+		c.Next()
+	}
+}
+
+// authenticate 返回请求的API令牌或cookie对应的用户,失败时已写入响应
+func authenticate(c *gin.Context) (User, Session, bool) {
+	// 自动化调用使用 Authorization: Bearer 令牌
+	if token, ok := bearerToken(c); ok {
+		user, err := tokenUser(token, c.ClientIP())
+		if err != nil {
+			abortAuth(c, http.StatusUnauthorized, ErrCodeUnauthorized, "Unauthorized: Invalid token")
+			return User{}, Session{}, false
+		}
+		return user, Session{}, true
+	}
+
+	// 从请求中获取cookie
+	cookieValue, err := c.Cookie(CookieName)
+	if err != nil {
+		abortAuth(c, http.StatusUnauthorized, ErrCodeUnauthorized, "Unauthorized: Cookie not provided")
+		return User{}, Session{}, false
+	}
+
+	session, err := LookupSession(cookieValue)
+	if err != nil {
+		abortAuth(c, http.StatusUnauthorized, ErrCodeUnauthorized, "Unauthorized: Invalid cookie")
+		return User{}, Session{}, false
+	}
+
+	// 每次请求都重新读取用户,删除用户或修改角色后立即生效
+	user, err := getUser(session.User)
+	if err != nil {
+		abortAuth(c, http.StatusUnauthorized, ErrCodeUnauthorized, "Unauthorized: User no longer exists")
+		return User{}, Session{}, false
+	}
+	user.SessionID = sessionID(cookieValue)
+	return user, session, true
+}
+
+// needsCSRF 判断请求是否会修改状态
+func needsCSRF(method, path string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return stateChangingGets[path]
+	}
+	return true
+}
+
+// validCSRF 比较请求头中的令牌和会话中的令牌,旧会话没有令牌,需要重新登入
+func validCSRF(header, expected string) bool {
+	return expected != "" && subtle.ConstantTimeCompare([]byte(header), []byte(expected)) == 1
+}
+
+// setSessionCookies 写入登入cookie和防CSRF令牌cookie,maxAge小于0时删除。
+// 登入cookie不能被页面脚本读取,防CSRF令牌需要被前端读取后放到请求头中,
+// 两者都只在同站请求中发送,使用https时只通过https发送
+func setSessionCookies(c *gin.Context, secure bool, cookie, csrf string, maxAge int) {
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(CookieName, cookie, maxAge, "/", "", secure, true)
+	c.SetCookie(CSRFCookieName, csrf, maxAge, "/", "", secure, false)
+}
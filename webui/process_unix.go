@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package webui
+
+import (
+	"os"
+	"os/exec"
+)
+
+// setNewConsole 非Windows系统没有新窗口,任务输出到WebUI所在的终端
+func setNewConsole(cmd *exec.Cmd) {
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+}
@@ -0,0 +1,17 @@
+//go:build windows
+// +build windows
+
+package webui
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// createNewConsole 即 CREATE_NEW_CONSOLE,让任务在新的控制台窗口中运行
+const createNewConsole = 0x00000010
+
+// setNewConsole 在新窗口中运行任务,与之前 start 命令的效果相同
+func setNewConsole(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: createNewConsole}
+}
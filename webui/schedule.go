@@ -0,0 +1,588 @@
+package webui
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/hoshinonyaruko/gensokyo-broadcast/audit"
+	"github.com/hoshinonyaruko/gensokyo-broadcast/config"
+	"github.com/hoshinonyaruko/gensokyo-broadcast/cron"
+)
+
+const ScheduleBucket = "schedules"
+
+// 停机期间错过的定时任务的处理方式
+const (
+	MissedSkip = "skip" // 跳过,等待下一次
+	MissedRun  = "run"  // 启动后补发一次,错过多次也只补发一次
+)
+
+// scheduleTimeLayout 一次性定时任务的时间格式
+const scheduleTimeLayout = "2006-01-02 15:04"
+
+// 定时任务检查间隔
+const scheduleTick = 20 * time.Second
+
+var ErrScheduleNotFound = errors.New("schedule not found")
+
+// Schedule 定时任务,一次性任务设置At,周期任务设置Cron,以json保存在bolt中
+type Schedule struct {
+	ID           string            `json:"id"`
+	Name         string            `json:"name"`
+	Params       map[string]string `json:"params"` // 与 /api/run 相同的参数
+	At           time.Time         `json:"at"`
+	Cron         string            `json:"cron,omitempty"`
+	MissedPolicy string            `json:"missed_policy,omitempty"` // 为空时使用配置中的scheduleMissedPolicy
+	Enabled      bool              `json:"enabled"`
+	NextRun      time.Time         `json:"next_run"`
+	LastRun      time.Time         `json:"last_run"`
+	LastSave     string            `json:"last_save,omitempty"` // 最近一次执行使用的存档名
+	LastError    string            `json:"last_error,omitempty"`
+	Missed       int               `json:"missed"` // 按skip策略跳过的次数
+	Created      time.Time         `json:"created"`
+	ApprovalID   string            `json:"approval_id,omitempty"`   // 需要审批时对应的审批
+	ApprovedHash string            `json:"approved_hash,omitempty"` // 审批通过时的参数哈希,修改参数或时间后不再匹配
+}
+
+// scheduleView 返回给WebUI的定时任务,时间格式化为本地时间,未设置时为空
+type scheduleView struct {
+	ID           string            `json:"id"`
+	Name         string            `json:"name"`
+	Params       map[string]string `json:"params"`
+	At           string            `json:"at,omitempty"`
+	Cron         string            `json:"cron,omitempty"`
+	MissedPolicy string            `json:"missed_policy,omitempty"`
+	Enabled      bool              `json:"enabled"`
+	NextRun      string            `json:"next_run,omitempty"`
+	LastRun      string            `json:"last_run,omitempty"`
+	LastSave     string            `json:"last_save,omitempty"`
+	LastError    string            `json:"last_error,omitempty"`
+	Missed       int               `json:"missed"`
+	Created      string            `json:"created"`
+	ApprovalID   string            `json:"approval_id,omitempty"`
+	Approval     string            `json:"approval,omitempty"` // pending等待审批,approved已审批,不需要审批时为空
+}
+
+func formatScheduleTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Local().Format(scheduleTimeLayout)
+}
+
+func (s Schedule) view() scheduleView {
+	return scheduleView{
+		ID:           s.ID,
+		Name:         s.Name,
+		Params:       s.Params,
+		At:           formatScheduleTime(s.At),
+		Cron:         s.Cron,
+		MissedPolicy: s.MissedPolicy,
+		Enabled:      s.Enabled,
+		NextRun:      formatScheduleTime(s.NextRun),
+		LastRun:      formatScheduleTime(s.LastRun),
+		LastSave:     s.LastSave,
+		LastError:    s.LastError,
+		Missed:       s.Missed,
+		Created:      formatScheduleTime(s.Created),
+		ApprovalID:   s.ApprovalID,
+		Approval:     s.approvalStatus(),
+	}
+}
+
+// when 返回执行时间的说明,参与审批的哈希
+func (s *Schedule) when() string {
+	if s.Cron != "" {
+		return "cron " + s.Cron
+	}
+	return formatScheduleTime(s.At)
+}
+
+func (s *Schedule) hash() string {
+	return campaignHash(s.Params, s.when())
+}
+
+// approvalStatus 返回审批状态,不需要审批时为空
+func (s *Schedule) approvalStatus() string {
+	switch {
+	case s.ApprovalID == "":
+		return ""
+	case s.ApprovedHash == s.hash():
+		return ApprovalApproved
+	default:
+		return ApprovalPending
+	}
+}
+
+// approveSchedule 审批通过定时任务,任务在提交审批后被修改时审批无效
+func approveSchedule(tx *bolt.Tx, a Approval) error {
+	data := tx.Bucket([]byte(ScheduleBucket)).Get([]byte(a.ScheduleID))
+	if data == nil {
+		return ErrScheduleNotFound
+	}
+	var s Schedule
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	if s.ApprovalID != a.ID || s.hash() != a.Hash {
+		return errors.New("schedule was changed after the approval was requested")
+	}
+	s.ApprovedHash = a.Hash
+	return putSchedule(tx, s)
+}
+
+// nextAfter 计算t之后的下一次执行时间,一次性任务已执行过时返回零值
+func (s *Schedule) nextAfter(t time.Time) (time.Time, error) {
+	if s.Cron == "" {
+		if s.At.After(t) {
+			return s.At, nil
+		}
+		return time.Time{}, nil
+	}
+	expr, err := cron.Parse(s.Cron)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return expr.Next(t), nil
+}
+
+// validate 检查定时任务,并计算下一次执行时间
+func (s *Schedule) validate(now time.Time) error {
+	if (s.Cron == "") == s.At.IsZero() {
+		return fmt.Errorf("exactly one of at and cron is required")
+	}
+	if s.Params["s"] == "" {
+		return fmt.Errorf("param s (save name) is required")
+	}
+	switch s.MissedPolicy {
+	case "", MissedSkip, MissedRun:
+	default:
+		return fmt.Errorf("invalid missed policy %q, use %s or %s", s.MissedPolicy, MissedSkip, MissedRun)
+	}
+	next, err := s.nextAfter(now)
+	if err != nil {
+		return err
+	}
+	if s.Enabled && next.IsZero() {
+		return fmt.Errorf("schedule will never run, at must be in the future")
+	}
+	s.NextRun = next
+	return nil
+}
+
+// saveName 返回本次执行的存档名。周期任务每次使用新的存档,否则第二次执行时所有目标都已有发送记录
+func (s *Schedule) saveName(runAt time.Time) string {
+	if s.Cron == "" {
+		return s.Params["s"]
+	}
+	return s.Params["s"] + "-" + runAt.Format("20060102-1504")
+}
+
+func loadSchedules(tx *bolt.Tx) ([]Schedule, error) {
+	var schedules []Schedule
+	bucket := tx.Bucket([]byte(ScheduleBucket))
+	err := bucket.ForEach(func(k, v []byte) error {
+		var s Schedule
+		if err := json.Unmarshal(v, &s); err != nil {
+			log.Printf("Invalid schedule %s: %v", k, err)
+			return nil
+		}
+		schedules = append(schedules, s)
+		return nil
+	})
+	return schedules, err
+}
+
+func putSchedule(tx *bolt.Tx, s Schedule) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket([]byte(ScheduleBucket)).Put([]byte(s.ID), data)
+}
+
+// StartScheduler 启动定时任务,需要在InitializeDB之后调用
+func StartScheduler(jsonconfig config.Config) {
+	err := dbcookie.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(ScheduleBucket))
+		return err
+	})
+	if err != nil {
+		log.Fatalf("Error creating schedule bucket: %v", err)
+	}
+	initApprovalBucket()
+
+	go func() {
+		// 启动时立即检查一次,处理停机期间错过的任务
+		runDueSchedules(jsonconfig, time.Now())
+		ticker := time.NewTicker(scheduleTick)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			runDueSchedules(jsonconfig, now)
+		}
+	}()
+}
+
+// scheduleRun 一次到期的执行,在数据库事务提交后启动
+type scheduleRun struct {
+	schedule Schedule
+	params   url.Values
+}
+
+// runDueSchedules 执行到期的定时任务。先在事务中更新执行记录和下一次执行时间,
+// 提交后再启动进程,启动失败时单独记录错误
+func runDueSchedules(jsonconfig config.Config, now time.Time) {
+	grace := time.Duration(jsonconfig.ScheduleGraceMinutes) * time.Minute
+	var due []scheduleRun
+	err := dbcookie.Update(func(tx *bolt.Tx) error {
+		due = nil
+		schedules, err := loadSchedules(tx)
+		if err != nil {
+			return err
+		}
+		for _, s := range schedules {
+			if !s.Enabled || s.NextRun.IsZero() || s.NextRun.After(now) {
+				continue
+			}
+
+			policy := s.MissedPolicy
+			if policy == "" {
+				policy = jsonconfig.ScheduleMissedPolicy
+			}
+			if s.approvalStatus() == ApprovalPending {
+				log.Printf("定时任务[%s]%s等待审批,跳过%s的执行", s.ID, s.Name, s.NextRun.Format(scheduleTimeLayout))
+				s.LastError = "waiting for approval"
+			} else if now.Sub(s.NextRun) > grace && policy != MissedRun {
+				log.Printf("定时任务[%s]%s错过了%s的执行,按%s策略跳过", s.ID, s.Name, s.NextRun.Format(scheduleTimeLayout), policy)
+				s.Missed++
+			} else {
+				due = append(due, scheduleRun{schedule: s, params: prepareRun(&s, now)})
+			}
+
+			s.NextRun, err = s.nextAfter(now)
+			if err != nil {
+				s.LastError = err.Error()
+				s.NextRun = time.Time{}
+			}
+			if s.NextRun.IsZero() {
+				// 一次性任务执行后停用
+				s.Enabled = false
+			}
+			if err := putSchedule(tx, s); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to run schedules: %v", err)
+		return
+	}
+	for _, run := range due {
+		runSchedule(run.schedule, run.params, now)
+	}
+}
+
+// prepareRun 记录本次执行并返回发送任务的参数
+func prepareRun(s *Schedule, now time.Time) url.Values {
+	params := paramsValues(s.Params)
+	save := s.saveName(now)
+	params.Set("s", save)
+
+	s.LastRun = now
+	s.LastSave = save
+	s.LastError = ""
+	return params
+}
+
+// runSchedule 启动定时任务对应的发送任务
+func runSchedule(s Schedule, params url.Values, now time.Time) {
+	launcher := audit.Launcher{User: "scheduler", JobID: audit.NewJobID()}
+	if err := startRunProcess(buildRunArgs(params), launcher); err != nil {
+		log.Printf("定时任务[%s]%s启动失败: %v", s.ID, s.Name, err)
+		recordScheduleError(s.ID, err)
+		return
+	}
+	log.Printf("定时任务[%s]%s已启动,存档名%s", s.ID, s.Name, s.LastSave)
+	audit.Record(audit.Entry{
+		Time:   now,
+		Action: "job_start",
+		User:   launcher.User,
+		Detail: map[string]interface{}{
+			"source":   "schedule",
+			"job":      launcher.JobID,
+			"schedule": s.ID,
+			"name":     s.Name,
+			"approval": s.ApprovalID,
+			"params":   audit.RedactParams(paramsMap(params)),
+		},
+	})
+}
+
+// recordScheduleError 记录定时任务启动失败的原因
+func recordScheduleError(id string, runErr error) {
+	err := dbcookie.Update(func(tx *bolt.Tx) error {
+		data := tx.Bucket([]byte(ScheduleBucket)).Get([]byte(id))
+		if data == nil {
+			return nil // 启动期间已被删除
+		}
+		var s Schedule
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		s.LastError = runErr.Error()
+		return putSchedule(tx, s)
+	})
+	if err != nil {
+		log.Printf("Failed to record schedule error: %v", err)
+	}
+}
+
+// scheduleRequest 创建或修改定时任务的请求体
+type scheduleRequest struct {
+	Name         string            `json:"name"`
+	Params       map[string]string `json:"params"`
+	At           string            `json:"at"` // 格式2006-01-02 15:04
+	Cron         string            `json:"cron"`
+	MissedPolicy string            `json:"missed_policy"`
+	Enabled      *bool             `json:"enabled"` // 不填为启用
+}
+
+func (r scheduleRequest) apply(s *Schedule) error {
+	s.Name = r.Name
+	s.Params = r.Params
+	s.Cron = r.Cron
+	s.MissedPolicy = r.MissedPolicy
+	s.At = time.Time{}
+	if r.At != "" {
+		at, err := time.ParseInLocation(scheduleTimeLayout, r.At, time.Local)
+		if err != nil {
+			return fmt.Errorf("invalid at time, use %s", scheduleTimeLayout)
+		}
+		s.At = at
+	}
+	s.Enabled = r.Enabled == nil || *r.Enabled
+	return s.validate(time.Now())
+}
+
+// useTemplate 把参数替换为s对应模板的参数
+func (r *scheduleRequest) useTemplate() error {
+	template, err := templateParams(paramsValues(r.Params))
+	if err != nil {
+		return err
+	}
+	r.Params = paramsMap(template)
+	return nil
+}
+
+// requestApproval 检查定时任务是否可能需要审批,需要时设置预演中的审批并返回审批记录,
+// 事务提交后需要调用startPreview。参数和执行时间没有变化时保留原来的审批
+func (s *Schedule) requestApproval(jsonconfig config.Config, old *Schedule, requester string) *Approval {
+	if old != nil && old.hash() == s.hash() {
+		return nil
+	}
+	s.ApprovalID = ""
+	s.ApprovedHash = ""
+	a := checkApproval(jsonconfig, paramsValues(s.Params))
+	if a == nil {
+		return nil
+	}
+	a.Kind = ApprovalKindSchedule
+	a.ScheduleID = s.ID
+	a.Name = s.Name
+	a.When = s.when()
+	a.Hash = s.hash()
+	a.Requester = requester
+	s.ApprovalID = a.ID
+	return a
+}
+
+// saveScheduleWithApproval 保存定时任务,之前的审批失效,a不为nil时同时保存新的审批
+func saveScheduleWithApproval(tx *bolt.Tx, s Schedule, a *Approval) error {
+	if a != nil || s.ApprovalID == "" {
+		err := expireApprovals(tx, func(old Approval) bool {
+			return old.Kind == ApprovalKindSchedule && old.ScheduleID == s.ID
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if a != nil {
+		if err := putApproval(tx, *a); err != nil {
+			return err
+		}
+	}
+	return putSchedule(tx, s)
+}
+
+// scheduleResponse 返回定时任务,需要审批时同时返回审批
+func scheduleResponse(s Schedule, a *Approval) gin.H {
+	response := gin.H{"schedule": s.view()}
+	if a != nil {
+		response["approval"] = a.view(false)
+		response["message"] = "Previewing, the schedule runs after approval if approval is required"
+	}
+	return response
+}
+
+// auditDetail 返回审计日志中记录的定时任务内容
+func (s Schedule) auditDetail(a *Approval) map[string]interface{} {
+	detail := map[string]interface{}{
+		"id":      s.ID,
+		"name":    s.Name,
+		"at":      formatScheduleTime(s.At),
+		"cron":    s.Cron,
+		"enabled": s.Enabled,
+		"params":  audit.RedactParams(s.Params),
+	}
+	if a != nil {
+		detail["approval"] = a.ID
+	}
+	return detail
+}
+
+// handleSchedules 处理 /schedules 路由的请求,GET列出,POST创建,PUT修改,DELETE删除。
+// 修改需要操作员,操作员只能定时运行已保存的模板
+func handleSchedules(c *gin.Context, jsonconfig config.Config) {
+	role := RoleOperator
+	if c.Request.Method == http.MethodGet {
+		role = RoleViewer
+	}
+	user, ok := authorize(c, role)
+	if !ok {
+		return
+	}
+
+	switch c.Request.Method {
+	case http.MethodGet:
+		var schedules []Schedule
+		err := dbcookie.View(func(tx *bolt.Tx) error {
+			var err error
+			schedules, err = loadSchedules(tx)
+			return err
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		views := make([]scheduleView, 0, len(schedules))
+		for _, s := range schedules {
+			views = append(views, s.view())
+		}
+		c.JSON(http.StatusOK, gin.H{"schedules": views})
+	case http.MethodPost:
+		var requestBody scheduleRequest
+		if err := c.BindJSON(&requestBody); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Bad request"})
+			return
+		}
+		if !user.hasRole(RoleAdmin) {
+			if err := requestBody.useTemplate(); err != nil {
+				abortAuth(c, http.StatusForbidden, ErrCodeForbidden, err.Error())
+				return
+			}
+		}
+		s := Schedule{ID: uuid.New().String()[:8], Created: time.Now()}
+		if err := requestBody.apply(&s); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		a := s.requestApproval(jsonconfig, nil, user.Name)
+		err := dbcookie.Update(func(tx *bolt.Tx) error {
+			return saveScheduleWithApproval(tx, s, a)
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if a != nil {
+			startPreview(jsonconfig, *a)
+		}
+		auditAction(c, user, "schedule_create", s.auditDetail(a))
+		c.JSON(http.StatusOK, scheduleResponse(s, a))
+	case http.MethodPut:
+		var requestBody scheduleRequest
+		if err := c.BindJSON(&requestBody); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Bad request"})
+			return
+		}
+		if !user.hasRole(RoleAdmin) {
+			if err := requestBody.useTemplate(); err != nil {
+				abortAuth(c, http.StatusForbidden, ErrCodeForbidden, err.Error())
+				return
+			}
+		}
+		// 在同一个事务中读取并修改,只替换用户可以修改的字段,
+		// 不会覆盖期间定时任务执行后更新的执行记录和下一次执行时间
+		var s Schedule
+		var a *Approval
+		var errBadRequest error
+		err := dbcookie.Update(func(tx *bolt.Tx) error {
+			data := tx.Bucket([]byte(ScheduleBucket)).Get([]byte(c.Query("id")))
+			if data == nil {
+				return ErrScheduleNotFound
+			}
+			var old Schedule
+			if err := json.Unmarshal(data, &old); err != nil {
+				return err
+			}
+			s = old
+			if errBadRequest = requestBody.apply(&s); errBadRequest != nil {
+				return errBadRequest
+			}
+			a = s.requestApproval(jsonconfig, &old, user.Name)
+			return saveScheduleWithApproval(tx, s, a)
+		})
+		if errBadRequest != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": errBadRequest.Error()})
+			return
+		}
+		if err == ErrScheduleNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if a != nil {
+			startPreview(jsonconfig, *a)
+		}
+		auditAction(c, user, "schedule_update", s.auditDetail(a))
+		c.JSON(http.StatusOK, scheduleResponse(s, a))
+	case http.MethodDelete:
+		err := dbcookie.Update(func(tx *bolt.Tx) error {
+			bucket := tx.Bucket([]byte(ScheduleBucket))
+			if bucket.Get([]byte(c.Query("id"))) == nil {
+				return ErrScheduleNotFound
+			}
+			err := expireApprovals(tx, func(old Approval) bool {
+				return old.Kind == ApprovalKindSchedule && old.ScheduleID == c.Query("id")
+			})
+			if err != nil {
+				return err
+			}
+			return bucket.Delete([]byte(c.Query("id")))
+		})
+		if err == ErrScheduleNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		auditAction(c, user, "schedule_delete", map[string]interface{}{"id": c.Query("id")})
+		c.JSON(http.StatusOK, gin.H{"message": "Schedule deleted"})
+	default:
+		c.JSON(http.StatusMethodNotAllowed, gin.H{"error": "Method not allowed"})
+	}
+}
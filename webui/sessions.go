@@ -0,0 +1,139 @@
+package webui
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/gin-gonic/gin"
+	"github.com/hoshinonyaruko/gensokyo-broadcast/config"
+)
+
+// sessionView 返回给WebUI的会话信息,不包含cookie本身
+type sessionView struct {
+	ID        string `json:"id"`
+	User      string `json:"user"`
+	IP        string `json:"ip,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+	Created   string `json:"created,omitempty"`
+	Expires   string `json:"expires"`
+	Current   bool   `json:"current"` // 是否为发起请求的会话
+}
+
+// handleLogout 处理 /logout 路由的请求,删除当前会话和浏览器中的cookie
+func handleLogout(c *gin.Context, jsonconfig config.Config) {
+	if cookieValue, err := c.Cookie(CookieName); err == nil {
+		session, lookupErr := LookupSession(cookieValue)
+		if err := DeleteCookie(cookieValue); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if lookupErr == nil {
+			auditAction(c, User{Name: session.User}, "logout", nil)
+		}
+	}
+	setSessionCookies(c, jsonconfig.UseHttps, "", "", -1)
+	c.JSON(http.StatusOK, gin.H{"isLoggedIn": false, "message": "Logged out"})
+}
+
+// handleSessions 处理 /sessions 路由的请求。
+// GET列出自己的会话,管理员加all=true列出全部;DELETE加id撤销单个会话,
+// 加all=true撤销自己除当前会话外的全部会话,管理员可以加user撤销该用户的全部会话
+func handleSessions(c *gin.Context) {
+	user, ok := authorize(c, RoleViewer)
+	if !ok {
+		return
+	}
+	if user.Token != "" {
+		abortAuth(c, http.StatusForbidden, ErrCodeForbidden, "Forbidden: API tokens cannot manage sessions")
+		return
+	}
+
+	switch c.Request.Method {
+	case http.MethodGet:
+		all := c.Query("all") == "true"
+		if all && !user.hasRole(RoleAdmin) {
+			abortAuth(c, http.StatusForbidden, ErrCodeForbidden, "Forbidden: requires admin role")
+			return
+		}
+		now := time.Now().Unix()
+		views := []sessionView{}
+		err := dbcookie.View(func(tx *bolt.Tx) error {
+			return tx.Bucket([]byte(CookieBucket)).ForEach(func(k, v []byte) error {
+				session, err := decodeSession(v)
+				if err != nil || now > session.Expiration || (!all && session.User != user.Name) {
+					return nil
+				}
+				view := sessionView{
+					ID:        sessionID(string(k)),
+					User:      session.User,
+					IP:        session.IP,
+					UserAgent: session.UserAgent,
+					Expires:   formatScheduleTime(time.Unix(session.Expiration, 0)),
+				}
+				if session.Created > 0 {
+					view.Created = formatScheduleTime(time.Unix(session.Created, 0))
+				}
+				view.Current = view.ID == user.SessionID
+				views = append(views, view)
+				return nil
+			})
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		sort.Slice(views, func(i, j int) bool { return views[i].Created > views[j].Created })
+		c.JSON(http.StatusOK, gin.H{"sessions": views})
+	case http.MethodDelete:
+		id, target := c.Query("id"), c.Query("user")
+		if target != "" && target != user.Name && !user.hasRole(RoleAdmin) {
+			abortAuth(c, http.StatusForbidden, ErrCodeForbidden, "Forbidden: requires admin role")
+			return
+		}
+		if id == "" && target == "" && c.Query("all") != "true" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "One of id, all=true or user is required"})
+			return
+		}
+
+		var deleted int
+		err := dbcookie.Update(func(tx *bolt.Tx) error {
+			var err error
+			deleted, err = deleteSessions(tx, func(cookie string, session Session, err error) bool {
+				if err != nil {
+					return false
+				}
+				switch {
+				case id != "":
+					return sessionID(cookie) == id && (session.User == user.Name || user.hasRole(RoleAdmin))
+				case target != "":
+					return session.User == target
+				default:
+					// 撤销自己的其他会话,保留当前会话
+					return session.User == user.Name && sessionID(cookie) != user.SessionID
+				}
+			})
+			return err
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if id != "" && deleted == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+			return
+		}
+		log.Printf("用户[%s]撤销了%d个登入会话", user.Name, deleted)
+		auditAction(c, user, "session_revoke", map[string]interface{}{
+			"id":      id,
+			"user":    target,
+			"all":     c.Query("all") == "true",
+			"revoked": deleted,
+		})
+		c.JSON(http.StatusOK, gin.H{"message": "Sessions revoked", "revoked": deleted})
+	default:
+		c.JSON(http.StatusMethodNotAllowed, gin.H{"error": "Method not allowed"})
+	}
+}
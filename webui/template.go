@@ -0,0 +1,98 @@
+package webui
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+// 模板中不需要值的布尔参数
+var boolParams = map[string]bool{"g": true, "f": true, "r": true, "n": true, "template": true}
+
+// loadTemplate 读取已保存的模板(运行任务时生成的 存档名.bat),转换为/run的参数
+func loadTemplate(name string) (url.Values, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return nil, fmt.Errorf("invalid template name %q", name)
+	}
+	executablePath, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(filepath.Dir(executablePath), name+".bat"))
+	if err != nil {
+		return nil, fmt.Errorf("template %s not found", name)
+	}
+	decoded, err := simplifiedchinese.GBK.NewDecoder().Bytes(data)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := splitCommandLine(strings.TrimSpace(string(decoded)))
+	params := url.Values{}
+	// 第一个是程序名
+	for i := 1; i < len(fields); i++ {
+		key := strings.TrimPrefix(fields[i], "-")
+		if boolParams[key] {
+			params.Set(key, "true")
+			continue
+		}
+		if i+1 < len(fields) {
+			params.Set(key, fields[i+1])
+			i++
+		}
+	}
+	return params, nil
+}
+
+// splitCommandLine 按空格分割命令行,双引号内的空格不分割
+func splitCommandLine(line string) []string {
+	var fields []string
+	var current strings.Builder
+	inQuotes, hasField := false, false
+	for _, r := range line {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			hasField = true
+		case (r == ' ' || r == '\t') && !inQuotes:
+			if hasField {
+				fields = append(fields, current.String())
+				current.Reset()
+				hasField = false
+			}
+		default:
+			current.WriteRune(r)
+			hasField = true
+		}
+	}
+	if hasField {
+		fields = append(fields, current.String())
+	}
+	return fields
+}
+
+// templateParams 返回s对应模板的参数,请求中的其他参数必须与模板一致,只允许额外加上预演n。
+// 操作员只能运行已保存的模板,不能修改消息、目标等内容
+func templateParams(params url.Values) (url.Values, error) {
+	name := params.Get("s")
+	template, err := loadTemplate(name)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range params {
+		if key == "s" || key == "n" || len(values) == 0 || values[0] == "" {
+			continue
+		}
+		if template.Get(key) != values[0] {
+			return nil, fmt.Errorf("parameter %s differs from template %s, operators can only run saved templates", key, name)
+		}
+	}
+	if params.Get("n") == "true" {
+		template.Set("n", "true")
+	}
+	return template, nil
+}
@@ -0,0 +1,267 @@
+package webui
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// TokenBucket 保存API令牌,键为令牌的sha256,不保存令牌本身
+const TokenBucket = "tokens"
+
+// tokenPrefix 令牌的前缀,方便在配置和日志中识别
+const tokenPrefix = "gb_"
+
+// 最近使用时间的更新间隔,避免每个请求都写数据库
+const tokenTouchInterval = time.Minute
+
+var ErrTokenNotFound = errors.New("token not found")
+
+// Token 用于自动化调用的API令牌,权限为Scope和所属用户角色中较低的一个
+type Token struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	Owner    string    `json:"owner"`
+	Scope    string    `json:"scope"` // viewer/operator/admin
+	Created  time.Time `json:"created"`
+	LastUsed time.Time `json:"last_used"`
+	LastIP   string    `json:"last_ip,omitempty"`
+}
+
+// tokenView 返回给WebUI的令牌信息
+type tokenView struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Owner    string `json:"owner"`
+	Scope    string `json:"scope"`
+	Created  string `json:"created"`
+	LastUsed string `json:"last_used,omitempty"`
+	LastIP   string `json:"last_ip,omitempty"`
+}
+
+func (t Token) view() tokenView {
+	return tokenView{
+		ID:       t.ID,
+		Name:     t.Name,
+		Owner:    t.Owner,
+		Scope:    t.Scope,
+		Created:  formatScheduleTime(t.Created),
+		LastUsed: formatScheduleTime(t.LastUsed),
+		LastIP:   t.LastIP,
+	}
+}
+
+func hashToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return []byte(hex.EncodeToString(sum[:]))
+}
+
+func initTokenBucket() {
+	err := dbcookie.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(TokenBucket))
+		return err
+	})
+	if err != nil {
+		log.Fatalf("Error creating token bucket: %v", err)
+	}
+}
+
+// bearerToken 返回Authorization: Bearer 请求头中的令牌
+func bearerToken(c *gin.Context) (string, bool) {
+	header := c.GetHeader("Authorization")
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	return strings.TrimSpace(token), ok
+}
+
+// tokenUser 返回令牌对应的用户,角色为令牌范围和用户角色中较低的一个,并记录最近使用时间
+func tokenUser(token, ip string) (User, error) {
+	key := hashToken(token)
+	var t Token
+	err := dbcookie.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket([]byte(TokenBucket)).Get(key)
+		if data == nil {
+			return ErrTokenNotFound
+		}
+		return json.Unmarshal(data, &t)
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	user, err := getUser(t.Owner)
+	if err != nil {
+		return User{}, err
+	}
+	if roleLevels[t.Scope] < roleLevels[user.Role] {
+		user.Role = t.Scope
+	}
+	user.Token = t.Name
+
+	now := time.Now()
+	if now.Sub(t.LastUsed) >= tokenTouchInterval || t.LastIP != ip {
+		t.LastUsed = now
+		t.LastIP = ip
+		dbcookie.Update(func(tx *bolt.Tx) error {
+			bucket := tx.Bucket([]byte(TokenBucket))
+			if bucket.Get(key) == nil {
+				return nil
+			}
+			data, err := json.Marshal(t)
+			if err != nil {
+				return err
+			}
+			return bucket.Put(key, data)
+		})
+	}
+	return user, nil
+}
+
+// deleteTokens 删除匹配的令牌
+func deleteTokens(tx *bolt.Tx, match func(Token) bool) (int, error) {
+	bucket := tx.Bucket([]byte(TokenBucket))
+	var keys [][]byte
+	err := bucket.ForEach(func(k, v []byte) error {
+		var t Token
+		if err := json.Unmarshal(v, &t); err != nil {
+			return err
+		}
+		if match(t) {
+			keys = append(keys, k)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, k := range keys {
+		if err := bucket.Delete(k); err != nil {
+			return 0, err
+		}
+	}
+	return len(keys), nil
+}
+
+// handleTokens 处理 /tokens 路由的请求,GET列出,POST创建,DELETE撤销。
+// 用户只能管理自己的令牌,管理员可以查看和撤销全部令牌。只能用登入的cookie管理令牌
+func handleTokens(c *gin.Context) {
+	user, ok := authorize(c, RoleViewer)
+	if !ok {
+		return
+	}
+	if user.Token != "" {
+		abortAuth(c, http.StatusForbidden, ErrCodeForbidden, "Forbidden: API tokens cannot manage tokens")
+		return
+	}
+	visible := func(t Token) bool {
+		return t.Owner == user.Name || user.hasRole(RoleAdmin)
+	}
+
+	switch c.Request.Method {
+	case http.MethodGet:
+		views := []tokenView{}
+		err := dbcookie.View(func(tx *bolt.Tx) error {
+			return tx.Bucket([]byte(TokenBucket)).ForEach(func(k, v []byte) error {
+				var t Token
+				if err := json.Unmarshal(v, &t); err != nil {
+					return err
+				}
+				if visible(t) {
+					views = append(views, t.view())
+				}
+				return nil
+			})
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"tokens": views})
+	case http.MethodPost:
+		var requestBody struct {
+			Name  string `json:"name"`
+			Scope string `json:"scope"` // 不填为用户自己的角色
+		}
+		if err := c.BindJSON(&requestBody); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Bad request"})
+			return
+		}
+		requestBody.Name = strings.TrimSpace(requestBody.Name)
+		if requestBody.Name == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Name is required"})
+			return
+		}
+		if requestBody.Scope == "" {
+			requestBody.Scope = user.Role
+		}
+		if _, ok := roleLevels[requestBody.Scope]; !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid scope, use viewer, operator or admin"})
+			return
+		}
+		if !user.hasRole(requestBody.Scope) {
+			abortAuth(c, http.StatusForbidden, ErrCodeForbidden, "Forbidden: scope exceeds your role")
+			return
+		}
+
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		token := tokenPrefix + hex.EncodeToString(secret)
+		t := Token{
+			ID:      uuid.New().String()[:8],
+			Name:    requestBody.Name,
+			Owner:   user.Name,
+			Scope:   requestBody.Scope,
+			Created: time.Now(),
+		}
+		err := dbcookie.Update(func(tx *bolt.Tx) error {
+			data, err := json.Marshal(t)
+			if err != nil {
+				return err
+			}
+			return tx.Bucket([]byte(TokenBucket)).Put(hashToken(token), data)
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("用户[%s]创建了API令牌[%s]%s,范围%s", user.Name, t.ID, t.Name, t.Scope)
+		auditAction(c, user, "token_create", map[string]interface{}{"id": t.ID, "name": t.Name, "scope": t.Scope})
+		// 令牌只在创建时返回一次
+		c.JSON(http.StatusOK, gin.H{"token": token, "info": t.view()})
+	case http.MethodDelete:
+		id := c.Query("id")
+		var deleted int
+		err := dbcookie.Update(func(tx *bolt.Tx) error {
+			var err error
+			deleted, err = deleteTokens(tx, func(t Token) bool {
+				return t.ID == id && visible(t)
+			})
+			return err
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if deleted == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": ErrTokenNotFound.Error()})
+			return
+		}
+		log.Printf("用户[%s]撤销了API令牌[%s]", user.Name, id)
+		auditAction(c, user, "token_revoke", map[string]interface{}{"id": id})
+		c.JSON(http.StatusOK, gin.H{"message": "Token revoked"})
+	default:
+		c.JSON(http.StatusMethodNotAllowed, gin.H{"error": "Method not allowed"})
+	}
+}
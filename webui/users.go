@@ -0,0 +1,310 @@
+package webui
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/gin-gonic/gin"
+	"github.com/hoshinonyaruko/gensokyo-broadcast/config"
+)
+
+// UserBucket 保存WebUI用户,config.json中的用户不在这里,总是管理员
+const UserBucket = "users"
+
+// 用户角色,后面的角色包含前面角色的全部权限
+const (
+	RoleViewer   = "viewer"   // 查看任务、预演报告和列表
+	RoleOperator = "operator" // 运行和定时运行已保存的模板
+	RoleAdmin    = "admin"    // 编辑列表、排除列表、配置和用户
+)
+
+var roleLevels = map[string]int{
+	RoleViewer:   1,
+	RoleOperator: 2,
+	RoleAdmin:    3,
+}
+
+var ErrUserNotFound = errors.New("user not found")
+
+var userNameRegex = regexp.MustCompile(`^[A-Za-z0-9_.@-]{1,32}$`)
+
+// User WebUI用户
+type User struct {
+	Name               string    `json:"name"`
+	PasswordHash       string    `json:"password_hash"`
+	Role               string    `json:"role"`
+	MustChangePassword bool      `json:"must_change_password"` // 管理员设置的初始密码,登入后需要先修改
+	Created            time.Time `json:"created"`
+	Builtin            bool      `json:"-"` // config.json中的用户
+	Token              string    `json:"-"` // 通过API令牌调用时为令牌名称
+	SessionID          string    `json:"-"` // 通过cookie调用时为会话编号
+}
+
+// userView 返回给WebUI的用户信息,不包含密码哈希
+type userView struct {
+	Name               string `json:"name"`
+	Role               string `json:"role"`
+	MustChangePassword bool   `json:"must_change_password"`
+	Builtin            bool   `json:"builtin"`
+	Created            string `json:"created,omitempty"`
+}
+
+func (u User) view() userView {
+	return userView{
+		Name:               u.Name,
+		Role:               u.Role,
+		MustChangePassword: u.MustChangePassword,
+		Builtin:            u.Builtin,
+		Created:            formatScheduleTime(u.Created),
+	}
+}
+
+// hasRole 判断用户是否有role或更高的角色
+func (u User) hasRole(role string) bool {
+	return roleLevels[u.Role] >= roleLevels[role]
+}
+
+func initUserBucket() {
+	err := dbcookie.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(UserBucket))
+		return err
+	})
+	if err != nil {
+		log.Fatalf("Error creating user bucket: %v", err)
+	}
+}
+
+func loadUsers(tx *bolt.Tx) ([]User, error) {
+	var users []User
+	err := tx.Bucket([]byte(UserBucket)).ForEach(func(k, v []byte) error {
+		var u User
+		if err := json.Unmarshal(v, &u); err != nil {
+			return err
+		}
+		users = append(users, u)
+		return nil
+	})
+	return users, err
+}
+
+func putUser(tx *bolt.Tx, u User) error {
+	data, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket([]byte(UserBucket)).Put([]byte(u.Name), data)
+}
+
+// getUser 按用户名查找用户,包括config.json中的用户
+func getUser(name string) (User, error) {
+	account.RLock()
+	if name == account.name {
+		u := User{Name: account.name, PasswordHash: account.hash, Role: RoleAdmin, MustChangePassword: account.mustChange, Builtin: true}
+		account.RUnlock()
+		return u, nil
+	}
+	account.RUnlock()
+
+	var u User
+	err := dbcookie.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket([]byte(UserBucket)).Get([]byte(name))
+		if data == nil {
+			return ErrUserNotFound
+		}
+		return json.Unmarshal(data, &u)
+	})
+	return u, err
+}
+
+// currentUser 返回AuthMiddleware认证通过的用户,失败时已写入响应
+func currentUser(c *gin.Context) (User, bool) {
+	if value, ok := c.Get(contextUserKey); ok {
+		if user, ok := value.(User); ok {
+			return user, true
+		}
+	}
+	abortAuth(c, http.StatusUnauthorized, ErrCodeUnauthorized, "Unauthorized: not logged in")
+	return User{}, false
+}
+
+// authorize 检查请求的用户是否已登入并且有role或更高的角色,失败时已写入响应
+func authorize(c *gin.Context, role string) (User, bool) {
+	user, ok := currentUser(c)
+	if !ok {
+		return user, false
+	}
+	// 还在使用默认或初始密码时,修改密码前不能使用其他接口
+	if user.MustChangePassword {
+		abortAuth(c, http.StatusForbidden, ErrCodePasswordChangeRequired, "Password change required")
+		return user, false
+	}
+	if !user.hasRole(role) {
+		abortAuth(c, http.StatusForbidden, ErrCodeForbidden, fmt.Sprintf("Forbidden: requires %s role", role))
+		return user, false
+	}
+	return user, true
+}
+
+// userRequest 创建或修改用户的请求体
+type userRequest struct {
+	Name     string `json:"name"`
+	Password string `json:"password"` // 创建时必填,修改时为空表示不修改
+	Role     string `json:"role"`     // 修改时为空表示不修改
+}
+
+// handleUsers 处理 /users 路由的请求,GET列出,POST创建,PUT修改角色或重置密码,DELETE删除,仅限管理员
+func handleUsers(c *gin.Context) {
+	admin, ok := authorize(c, RoleAdmin)
+	if !ok {
+		return
+	}
+
+	switch c.Request.Method {
+	case http.MethodGet:
+		builtin, _ := getUser(builtinAccount())
+		views := []userView{builtin.view()}
+		err := dbcookie.View(func(tx *bolt.Tx) error {
+			users, err := loadUsers(tx)
+			for _, u := range users {
+				views = append(views, u.view())
+			}
+			return err
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"users": views})
+	case http.MethodPost:
+		var requestBody userRequest
+		if err := c.BindJSON(&requestBody); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Bad request"})
+			return
+		}
+		if !userNameRegex.MatchString(requestBody.Name) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user name, use 1-32 letters, digits or _.@-"})
+			return
+		}
+		if _, ok := roleLevels[requestBody.Role]; !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role, use viewer, operator or admin"})
+			return
+		}
+		if len(requestBody.Password) < config.MinPasswordLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Password must be at least %d characters", config.MinPasswordLength)})
+			return
+		}
+		hash, err := config.HashPassword(requestBody.Password)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		// 管理员设置的是初始密码,用户登入后需要修改为只有自己知道的密码
+		u := User{Name: requestBody.Name, PasswordHash: hash, Role: requestBody.Role, MustChangePassword: true, Created: time.Now()}
+		err = dbcookie.Update(func(tx *bolt.Tx) error {
+			if u.Name == builtinAccount() || tx.Bucket([]byte(UserBucket)).Get([]byte(u.Name)) != nil {
+				return fmt.Errorf("user %s already exists", u.Name)
+			}
+			return putUser(tx, u)
+		})
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		auditAction(c, admin, "user_create", map[string]interface{}{"name": u.Name, "role": u.Role})
+		c.JSON(http.StatusOK, gin.H{"user": u.view()})
+	case http.MethodPut:
+		var requestBody userRequest
+		if err := c.BindJSON(&requestBody); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Bad request"})
+			return
+		}
+		if requestBody.Role != "" {
+			if _, ok := roleLevels[requestBody.Role]; !ok {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role, use viewer, operator or admin"})
+				return
+			}
+		}
+		var hash string
+		if requestBody.Password != "" {
+			if len(requestBody.Password) < config.MinPasswordLength {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Password must be at least %d characters", config.MinPasswordLength)})
+				return
+			}
+			var err error
+			if hash, err = config.HashPassword(requestBody.Password); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		var u User
+		err := dbcookie.Update(func(tx *bolt.Tx) error {
+			data := tx.Bucket([]byte(UserBucket)).Get([]byte(c.Query("name")))
+			if data == nil {
+				return ErrUserNotFound
+			}
+			if err := json.Unmarshal(data, &u); err != nil {
+				return err
+			}
+			if requestBody.Role != "" {
+				u.Role = requestBody.Role
+			}
+			if hash != "" {
+				// 重置密码后需要重新登入并修改密码
+				u.PasswordHash = hash
+				u.MustChangePassword = true
+				if err := deleteUserSessions(tx, u.Name); err != nil {
+					return err
+				}
+			}
+			return putUser(tx, u)
+		})
+		if err == ErrUserNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found, the config.json user can only be changed with change-password or the passwd command"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		auditAction(c, admin, "user_update", map[string]interface{}{
+			"name":           u.Name,
+			"role":           u.Role,
+			"password_reset": hash != "",
+		})
+		c.JSON(http.StatusOK, gin.H{"user": u.view()})
+	case http.MethodDelete:
+		name := c.Query("name")
+		err := dbcookie.Update(func(tx *bolt.Tx) error {
+			bucket := tx.Bucket([]byte(UserBucket))
+			if bucket.Get([]byte(name)) == nil {
+				return ErrUserNotFound
+			}
+			if err := bucket.Delete([]byte(name)); err != nil {
+				return err
+			}
+			// 同时删除该用户的令牌
+			if _, err := deleteTokens(tx, func(t Token) bool { return t.Owner == name }); err != nil {
+				return err
+			}
+			return deleteUserSessions(tx, name)
+		})
+		if err == ErrUserNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		auditAction(c, admin, "user_delete", map[string]interface{}{"name": name})
+		c.JSON(http.StatusOK, gin.H{"message": "User deleted"})
+	default:
+		c.JSON(http.StatusMethodNotAllowed, gin.H{"error": "Method not allowed"})
+	}
+}
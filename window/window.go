@@ -0,0 +1,169 @@
+package window
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// span 一天中允许发送的时段,单位为从0点开始的分钟,end小于start时跨过午夜
+type span struct {
+	start, end int
+}
+
+// Window 允许发送的时段,使用本地时间
+type Window struct {
+	spec string
+	days [7][]span
+}
+
+// Parse 解析发送时段,多条规则用;分隔,每条规则为 [星期] 时段[,时段...]。
+// 如 "09:00-22:00" 表示每天9点到22点,"mon-fri 09:00-22:00; sat,sun 10:00-12:00,14:00-21:00"
+// 为按星期设置,没有出现的星期全天不发送。时段可以跨过午夜,如 "22:00-02:00"
+func Parse(spec string) (*Window, error) {
+	w := &Window{spec: spec}
+	for _, rule := range strings.Split(spec, ";") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		fields := strings.Fields(rule)
+		days := []time.Weekday{time.Sunday, time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday}
+		ranges := fields[0]
+		if len(fields) == 2 {
+			var err error
+			if days, err = parseDays(fields[0]); err != nil {
+				return nil, err
+			}
+			ranges = fields[1]
+		} else if len(fields) > 2 {
+			return nil, fmt.Errorf("invalid send window rule %q", rule)
+		}
+		for _, r := range strings.Split(ranges, ",") {
+			s, err := parseSpan(r)
+			if err != nil {
+				return nil, err
+			}
+			for _, day := range days {
+				w.days[day] = append(w.days[day], s)
+			}
+		}
+	}
+	if w.empty() {
+		return nil, fmt.Errorf("send window %q has no time range", spec)
+	}
+	return w, nil
+}
+
+func (w *Window) empty() bool {
+	for _, spans := range w.days {
+		if len(spans) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func parseDays(s string) ([]time.Weekday, error) {
+	var days []time.Weekday
+	for _, part := range strings.Split(strings.ToLower(s), ",") {
+		if part == "daily" || part == "*" {
+			return []time.Weekday{time.Sunday, time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday}, nil
+		}
+		from, to, isRange := strings.Cut(part, "-")
+		start, ok := weekdays[from]
+		if !ok {
+			return nil, fmt.Errorf("invalid weekday %q, use mon tue wed thu fri sat sun", from)
+		}
+		end := start
+		if isRange {
+			if end, ok = weekdays[to]; !ok {
+				return nil, fmt.Errorf("invalid weekday %q, use mon tue wed thu fri sat sun", to)
+			}
+		}
+		// fri-mon 这样的范围跨过周日
+		for day := start; ; day = (day + 1) % 7 {
+			days = append(days, day)
+			if day == end {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+func parseSpan(s string) (span, error) {
+	from, to, ok := strings.Cut(strings.TrimSpace(s), "-")
+	if !ok {
+		return span{}, fmt.Errorf("invalid time range %q, use 09:00-22:00", s)
+	}
+	start, err := parseClock(from)
+	if err != nil {
+		return span{}, err
+	}
+	end, err := parseClock(to)
+	if err != nil {
+		return span{}, err
+	}
+	if start == end {
+		return span{}, fmt.Errorf("empty time range %q", s)
+	}
+	return span{start, end}, nil
+}
+
+func parseClock(s string) (int, error) {
+	var hour, minute int
+	if _, err := fmt.Sscanf(s, "%d:%d", &hour, &minute); err != nil || hour < 0 || hour > 24 || minute < 0 || minute > 59 || (hour == 24 && minute != 0) {
+		return 0, fmt.Errorf("invalid time %q, use HH:MM", s)
+	}
+	return hour*60 + minute, nil
+}
+
+// String 返回原始设置
+func (w *Window) String() string {
+	return w.spec
+}
+
+// Open 判断t是否在允许发送的时段内,未设置时段(nil)时总是允许
+func (w *Window) Open(t time.Time) bool {
+	if w == nil {
+		return true
+	}
+	minute := t.Hour()*60 + t.Minute()
+	for _, s := range w.days[t.Weekday()] {
+		if s.start < s.end && minute >= s.start && minute < s.end {
+			return true
+		}
+		if s.start > s.end && minute >= s.start {
+			return true
+		}
+	}
+	// 前一天跨过午夜的时段
+	for _, s := range w.days[(t.Weekday()+6)%7] {
+		if s.start > s.end && minute < s.end {
+			return true
+		}
+	}
+	return false
+}
+
+// NextOpen 返回t之后最早允许发送的时间,t在时段内时返回t
+func (w *Window) NextOpen(t time.Time) time.Time {
+	if w.Open(t) {
+		return t
+	}
+	next := t.Truncate(time.Minute)
+	// 每周至少有一个时段,最多查找8天
+	for i := 0; i < 8*24*60; i++ {
+		next = next.Add(time.Minute)
+		if w.Open(next) {
+			return next
+		}
+	}
+	return t
+}
@@ -0,0 +1,111 @@
+package window
+
+import (
+	"testing"
+	"time"
+)
+
+// at 返回从2026-10-18(周日)开始的那一周中day的clock时刻
+func at(day time.Weekday, clock string) time.Time {
+	t, err := time.ParseInLocation("15:04", clock, time.Local)
+	if err != nil {
+		panic(err)
+	}
+	return time.Date(2026, 10, 18+int(day), t.Hour(), t.Minute(), 0, 0, time.Local)
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		";",
+		"09:00",
+		"09:00-09:00",
+		"25:00-26:00",
+		"09:60-10:00",
+		"24:30-01:00",
+		"9-22",
+		"foo 09:00-22:00",
+		"mon-foo 09:00-22:00",
+		"mon 09:00-22:00 extra",
+	}
+	for _, spec := range tests {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", spec)
+		}
+	}
+}
+
+func TestOpen(t *testing.T) {
+	tests := []struct {
+		spec string
+		at   time.Time
+		want bool
+	}{
+		{"09:00-22:00", at(time.Monday, "09:00"), true},
+		{"09:00-22:00", at(time.Monday, "21:59"), true},
+		{"09:00-22:00", at(time.Monday, "22:00"), false},
+		{"09:00-22:00", at(time.Monday, "08:59"), false},
+		{"00:00-24:00", at(time.Sunday, "23:59"), true},
+		// 跨过午夜
+		{"22:00-02:00", at(time.Monday, "23:30"), true},
+		{"22:00-02:00", at(time.Tuesday, "01:59"), true},
+		{"22:00-02:00", at(time.Tuesday, "02:00"), false},
+		{"22:00-02:00", at(time.Monday, "12:00"), false},
+		// 周日晚上跨到周一凌晨
+		{"sun 22:00-02:00", at(time.Monday, "01:00"), true},
+		{"sun 22:00-02:00", at(time.Sunday, "01:00"), false},
+		// 按星期设置
+		{"mon-fri 09:00-22:00; sat,sun 10:00-12:00,14:00-21:00", at(time.Saturday, "11:00"), true},
+		{"mon-fri 09:00-22:00; sat,sun 10:00-12:00,14:00-21:00", at(time.Saturday, "13:00"), false},
+		{"mon-fri 09:00-22:00; sat,sun 10:00-12:00,14:00-21:00", at(time.Friday, "21:00"), true},
+		{"mon-fri 09:00-22:00", at(time.Sunday, "12:00"), false},
+		// 跨过周日的星期范围
+		{"fri-mon 09:00-22:00", at(time.Sunday, "12:00"), true},
+		{"fri-mon 09:00-22:00", at(time.Monday, "12:00"), true},
+		{"fri-mon 09:00-22:00", at(time.Wednesday, "12:00"), false},
+		{"daily 09:00-10:00", at(time.Wednesday, "09:30"), true},
+	}
+	for _, tt := range tests {
+		w, err := Parse(tt.spec)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.spec, err)
+			continue
+		}
+		if got := w.Open(tt.at); got != tt.want {
+			t.Errorf("%q Open(%s) = %v, want %v", tt.spec, tt.at.Format("Mon 15:04"), got, tt.want)
+		}
+	}
+}
+
+func TestNextOpen(t *testing.T) {
+	tests := []struct {
+		spec string
+		at   time.Time
+		want time.Time
+	}{
+		{"09:00-22:00", at(time.Monday, "10:00"), at(time.Monday, "10:00")},
+		{"09:00-22:00", at(time.Monday, "23:00"), at(time.Tuesday, "09:00")},
+		{"09:00-22:00", at(time.Monday, "06:00"), at(time.Monday, "09:00")},
+		{"22:00-02:00", at(time.Tuesday, "03:00"), at(time.Tuesday, "22:00")},
+		{"mon-fri 09:00-22:00", at(time.Friday, "22:30"), at(time.Monday, "09:00").AddDate(0, 0, 7)},
+		{"sat 10:00-12:00", at(time.Saturday, "12:00"), at(time.Saturday, "10:00").AddDate(0, 0, 7)},
+	}
+	for _, tt := range tests {
+		w, err := Parse(tt.spec)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.spec, err)
+			continue
+		}
+		if got := w.NextOpen(tt.at); !got.Equal(tt.want) {
+			t.Errorf("%q NextOpen(%s) = %s, want %s", tt.spec, tt.at.Format("Mon 01-02 15:04"), got.Format("Mon 01-02 15:04"), tt.want.Format("Mon 01-02 15:04"))
+		}
+	}
+}
+
+func TestNilWindow(t *testing.T) {
+	var w *Window
+	now := at(time.Monday, "03:00")
+	if !w.Open(now) || !w.NextOpen(now).Equal(now) {
+		t.Error("nil window should always be open")
+	}
+}